@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/harshyadavone/anonymous_chat/store"
+	"github.com/harshyadavone/tgx"
+	"github.com/harshyadavone/tgx/models"
+)
+
+// How many pending reports /reports lists at once.
+const pendingReportsPage = 10
+
+// How many recent sessions /user lists.
+const recentSessionsPage = 5
+
+// How many days of crisis phrase counts /stats shows.
+const crisisMetricDays = 7
+
+// How many days of chats and bans /stats shows.
+const statsMetricDays = 7
+
+func isAdmin(chatId int64) bool {
+	return slices.Contains(config.AdminChatIds, chatId)
+}
+
+// adminOnly ignores the command unless it comes from one of the admin chats,
+// so the commands stay invisible to everyone else.
+func adminOnly(handler tgx.Handler) tgx.Handler {
+	return func(ctx *tgx.Context) error {
+		if !isAdmin(ctx.ChatID) {
+			log.Printf("WARN: Chat %d tried to use an admin command.", ctx.ChatID)
+			return nil
+		}
+		return handler(ctx)
+	}
+}
+
+// parseChatId reads the chat ID an admin command is about.
+func parseChatId(args []string) (int64, bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	chatId, err := strconv.ParseInt(args[0], 10, 64)
+	return chatId, err == nil
+}
+
+// parseBanDuration reads a ban duration such as "12h" or "7d".
+func parseBanDuration(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid number of days %q", days)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return d, nil
+}
+
+// HandleAdminBan bans a user for a duration, or permanently without one.
+func HandleAdminBan(ctx *tgx.Context) error {
+	chatId, ok := parseChatId(ctx.Args)
+	if !ok {
+		return ctx.Reply(MessageAdminBanUsage)
+	}
+	var duration time.Duration
+	if len(ctx.Args) > 1 {
+		d, err := parseBanDuration(ctx.Args[1])
+		if err != nil {
+			return ctx.Reply(MessageAdminBanUsage)
+		}
+		duration = d
+	}
+
+	user, err := GetUser(context.Background(), chatId)
+	if err != nil {
+		return ctx.Reply(fmt.Sprintf(MessageAdminUserNotFound, chatId))
+	}
+
+	banUser(user, duration)
+	if err := enforceBan(bot, user); err != nil {
+		log.Printf("ERROR: Failed to ban user %d: %v", chatId, err)
+		return ctx.Reply(MessageErrSomethingWentWrong)
+	}
+	log.Printf("LOG: Admin %d banned user %d for %v.", ctx.ChatID, chatId, duration)
+	return ctx.Reply(fmt.Sprintf(MessageAdminBanned, chatId, banEnd(user)))
+}
+
+func HandleAdminUnban(ctx *tgx.Context) error {
+	chatId, ok := parseChatId(ctx.Args)
+	if !ok {
+		return ctx.Reply(MessageAdminUnbanUsage)
+	}
+
+	user, err := GetUser(context.Background(), chatId)
+	if err != nil {
+		return ctx.Reply(fmt.Sprintf(MessageAdminUserNotFound, chatId))
+	}
+
+	unbanUser(user)
+	if err := UpdateUser(context.Background(), user); err != nil {
+		log.Printf("ERROR: Failed to unban user %d: %v", chatId, err)
+		return ctx.Reply(MessageErrSomethingWentWrong)
+	}
+	log.Printf("LOG: Admin %d unbanned user %d.", ctx.ChatID, chatId)
+	bot.SendMessage(chatId, MessageUnbanned)
+	return ctx.Reply(fmt.Sprintf(MessageAdminUnbanned, chatId))
+}
+
+// HandleAdminUser shows the profile of a user with the reports against them
+// and their recent sessions.
+func HandleAdminUser(ctx *tgx.Context) error {
+	chatId, ok := parseChatId(ctx.Args)
+	if !ok {
+		return ctx.Reply(MessageAdminUserUsage)
+	}
+	bg := context.Background()
+
+	user, err := GetUser(bg, chatId)
+	if err != nil {
+		return ctx.Reply(fmt.Sprintf(MessageAdminUserNotFound, chatId))
+	}
+	reports, err := userStore.GetReports(bg, chatId)
+	if err != nil {
+		log.Printf("ERROR: Failed to get reports against user %d: %v", chatId, err)
+		return ctx.Reply(MessageErrSomethingWentWrong)
+	}
+
+	ratings, err := userStore.GetRecentRatings(bg, chatId, recentSessionsPage)
+	if err != nil {
+		log.Printf("ERROR: Failed to get recent sessions of user %d: %v", chatId, err)
+		return ctx.Reply(MessageErrSomethingWentWrong)
+	}
+
+	counts := make(map[string]int)
+	reasons := make(map[string]int)
+	for _, report := range reports {
+		counts[report.Status]++
+		reasons[report.Reason]++
+	}
+	var byReason []string
+	for _, reason := range append(reportReasons, ReportReasonProfanity) {
+		if reasons[reason] > 0 {
+			byReason = append(byReason, fmt.Sprintf("%s %d", reason, reasons[reason]))
+		}
+	}
+
+	state := "idle"
+	switch {
+	case user.IsConnected:
+		state = fmt.Sprintf("chatting with %d (session %s)", user.Partner, user.SessionId)
+	case user.IsConnecting != 0:
+		state = "waiting for a partner"
+	}
+
+	sessions := MessageAdminUserNoSessions
+	if len(ratings) > 0 {
+		lines := make([]string, len(ratings))
+		for i, rating := range ratings {
+			lines[i] = fmt.Sprintf(MessageAdminUserSession, rating.SessionId, rating.RatedChatId,
+				time.Unix(rating.CreatedAt, 0).UTC().Format(banTimeLayout))
+		}
+		sessions = strings.Join(lines, "\n")
+	}
+
+	return ctx.Reply(fmt.Sprintf(MessageAdminUserProfile,
+		user.ChatId, state, user.Gender, user.PartnerGender, user.RulesVersion, user.Minor, user.SessionCount, trustLevelNames[trustLevel(user, time.Now())],
+		user.RatingScore(), user.RatingsUp, user.RatingsDown,
+		reportScore(user), len(reports), counts[store.ReportPending], counts[store.ReportUpheld], counts[store.ReportDismissed],
+		strings.Join(byReason, ", "),
+		user.ReportsUpheld, user.ReportsDismissed, reporterCredibility(user),
+		user.FlagCount, user.PIICount, user.ProfanityCount,
+		user.BanCount, banEnd(user), shadowEnd(user), user.Inactive,
+		sessions))
+}
+
+// HandleAdminReports lists the oldest pending reports with their buttons.
+func HandleAdminReports(ctx *tgx.Context) error {
+	bg := context.Background()
+	reports, err := userStore.GetPendingReports(bg, pendingReportsPage)
+	if err != nil {
+		log.Printf("ERROR: Failed to get pending reports: %v", err)
+		return ctx.Reply(MessageErrSomethingWentWrong)
+	}
+	if len(reports) == 0 {
+		return ctx.Reply(MessageAdminNoReports)
+	}
+
+	for i := range reports {
+		reported, err := GetUser(bg, reports[i].ReportedChatId)
+		if err != nil {
+			reported = &store.User{ChatId: reports[i].ReportedChatId}
+		}
+		if err := sendReport(ctx.ChatID, &reports[i], reported); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func HandleAdminStats(ctx *tgx.Context) error {
+	stats, err := userStore.GetStats(context.Background())
+	if err != nil {
+		log.Printf("ERROR: Failed to get stats: %v", err)
+		return ctx.Reply(MessageErrSomethingWentWrong)
+	}
+	chats, err := userStore.GetMetric(context.Background(), "chats", statsMetricDays)
+	if err != nil {
+		log.Printf("ERROR: Failed to get chats metric: %v", err)
+	}
+	bans, err := userStore.GetMetric(context.Background(), "bans", statsMetricDays)
+	if err != nil {
+		log.Printf("ERROR: Failed to get bans metric: %v", err)
+	}
+	text := fmt.Sprintf(MessageAdminStats, stats.Users, stats.Queued, stats.PendingReports,
+		statsMetricDays, sumMetric(chats), sumMetric(bans))
+
+	if config.CrisisDetection {
+		counts, err := userStore.GetMetric(context.Background(), "crisis", crisisMetricDays)
+		if err != nil {
+			log.Printf("ERROR: Failed to get crisis metric: %v", err)
+		}
+		var byLanguage []string
+		for _, list := range crisisLists {
+			if counts[list.Language] > 0 {
+				byLanguage = append(byLanguage, fmt.Sprintf("%s %d", list.Language, counts[list.Language]))
+			}
+		}
+		if byLanguage == nil {
+			byLanguage = []string{"none"}
+		}
+		text += "\n" + fmt.Sprintf(MessageAdminCrisisStats, crisisMetricDays, strings.Join(byLanguage, ", "))
+	}
+	return ctx.Reply(text)
+}
+
+// notifyAdmins sends a new report to every admin chat.
+func notifyAdmins(report *store.Report, reported *store.User) {
+	for _, chatId := range config.AdminChatIds {
+		if err := sendReport(chatId, report, reported); err != nil {
+			log.Printf("ERROR: Failed to send report against %d to admin chat %d: %v", report.ReportedChatId, chatId, err)
+		}
+	}
+}
+
+// sendReport shows a report in an admin chat with buttons to decide on it.
+func sendReport(chatId int64, report *store.Report, reported *store.User) error {
+	reporter := "the bot"
+	if report.ReporterChatId != 0 {
+		reporter = strconv.FormatInt(report.ReporterChatId, 10)
+	}
+	evidence := "none"
+	if len(report.EvidenceMessageIds) > 0 {
+		evidence = fmt.Sprintf("%d messages in chat %d", len(report.EvidenceMessageIds), report.EvidenceChatId)
+	}
+
+	text := fmt.Sprintf(MessageAdminReport,
+		report.ReportedChatId, reportScore(reported), reported.RatingScore(), reported.BanCount,
+		reporter, report.Weight, report.Reason,
+		time.Unix(report.CreatedAt, 0).UTC().Format(banTimeLayout), report.SessionId, evidence)
+
+	id := fmt.Sprintf("%d:%s", report.ReportedChatId, report.ReportKey)
+	return bot.SendMessageWithOpts(&tgx.SendMessageRequest{
+		ChatId: chatId,
+		Text:   text,
+		ReplyMarkup: models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{{
+			{Text: "Approve", CallbackData: CallbackAdminApprove + id},
+			{Text: "Dismiss", CallbackData: CallbackAdminDismiss + id},
+			{Text: "Ban", CallbackData: CallbackAdminBan + id},
+		}}},
+	})
+}
+
+// HandleAdminDecision upholds or dismisses a report from its buttons, and
+// bans the reported user for the Ban button. The reporter's credibility and
+// the reported user's score follow the decision.
+func HandleAdminDecision(b *tgx.Bot, query *CallbackQuery) error {
+	if query.Message == nil || (!isAdmin(query.Message.Chat.Id) && !isAdmin(query.From.Id)) {
+		log.Printf("WARN: User %d pressed an admin button outside an admin chat.", query.From.Id)
+		return answerCallbackQuery(query.Id, "", false)
+	}
+
+	action, id, _ := strings.Cut(query.Data, ":")
+	reportedId, reportKey, _ := strings.Cut(id, ":")
+	reportedChatId, err := strconv.ParseInt(reportedId, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid report in callback data %q", query.Data)
+	}
+
+	status := store.ReportUpheld
+	if action+":" == CallbackAdminDismiss {
+		status = store.ReportDismissed
+	}
+
+	bg := context.Background()
+	report, err := userStore.DecideReport(bg, reportedChatId, reportKey, status, query.From.Id)
+	if errors.Is(err, store.ErrReportDecided) {
+		return answerCallbackQuery(query.Id, MessageAdminReportDecided, true)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("LOG: Admin %d marked report %s against %d as %s.", query.From.Id, reportKey, reportedChatId, status)
+
+	if report.ReporterChatId != 0 {
+		if reporter, err := GetUser(bg, report.ReporterChatId); err == nil {
+			if status == store.ReportUpheld {
+				reporter.ReportsUpheld++
+			} else {
+				reporter.ReportsDismissed++
+			}
+			if err := UpdateUser(bg, reporter); err != nil {
+				log.Printf("ERROR: Failed to update credibility of reporter %d: %v", reporter.ChatId, err)
+			}
+		}
+	}
+
+	decision := status
+	reported, err := GetUser(bg, reportedChatId)
+	if err == nil {
+		switch action + ":" {
+		case CallbackAdminDismiss:
+			if err := recomputeReportScore(bg, reported); err != nil {
+				log.Printf("ERROR: Failed to recompute report score of user %d: %v", reportedChatId, err)
+			} else if err := UpdateUser(bg, reported); err != nil {
+				log.Printf("ERROR: Failed to update report score of user %d: %v", reportedChatId, err)
+			}
+		case CallbackAdminBan:
+			banUser(reported, banDuration(reported, 0))
+			if err := enforceBan(b, reported); err != nil {
+				log.Printf("ERROR: Failed to ban user %d: %v", reportedChatId, err)
+			}
+			decision = "banned until " + banEnd(reported)
+		}
+	}
+
+	if query.Message.Text != "" {
+		text := query.Message.Text + "\n\n" + fmt.Sprintf(MessageAdminDecided, decision, query.From.Id)
+		if err := editMessageText(query.Message.Chat.Id, query.Message.MessageId, text); err != nil {
+			log.Printf("WARN: Failed to update report message in chat %d: %v", query.Message.Chat.Id, err)
+		}
+	}
+	return answerCallbackQuery(query.Id, "", false)
+}
+
+// banEnd describes when a user's ban ends for moderators.
+func banEnd(user *store.User) string {
+	switch {
+	case user.BanPermanent:
+		return "permanent"
+	case user.IsBanned(time.Now()):
+		return time.Unix(user.BannedUntil, 0).UTC().Format(banTimeLayout)
+	}
+	return "not banned"
+}
+
+// shadowEnd describes until when a user is kept in the shadow pool.
+func shadowEnd(user *store.User) string {
+	if user.Queue(time.Now()) != store.QueueShadow {
+		return "no"
+	}
+	return "until " + time.Unix(user.ShadowUntil, 0).UTC().Format(banTimeLayout)
+}
+
+// sumMetric adds up the counts of a metric over all its labels.
+func sumMetric(counts map[string]int64) int64 {
+	var total int64
+	for _, count := range counts {
+		total += count
+	}
+	return total
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/harshyadavone/tgx"
+
+	"github.com/harshyadavone/anonymous_chat/store"
+)
+
+// Telegram delivers the parts of an album as separate updates, usually to
+// separate Lambda invocations, within a second or so of each other. The
+// invocation with the first part waits this long for the others and sends the
+// whole album; the rest only store their part. A part that arrives after the
+// wait, as they all do when Telegram delivers a chat's updates one at a time,
+// is relayed on its own.
+const albumWait = 1500 * time.Millisecond
+
+// albumPart extracts the media of a message that belongs to an album.
+func albumPart(msg *Message) (*store.MediaGroupPart, bool) {
+	part := &store.MediaGroupPart{
+		GroupId:   fmt.Sprintf("%d:%s", msg.Chat.Id, msg.MediaGroupId),
+		MessageId: msg.MessageId,
+		ChatId:    msg.Chat.Id,
+		Caption:   msg.Caption,
+	}
+
+	switch {
+	case len(msg.Photo) > 0:
+		part.Type = "photo"
+		part.FileId = msg.Photo[len(msg.Photo)-1].FileId
+	case msg.Video != nil:
+		part.Type = "video"
+		part.FileId = msg.Video.FileId
+	case msg.Document != nil:
+		part.Type = "document"
+		part.FileId = msg.Document.FileId
+	case msg.Audio != nil:
+		part.Type = "audio"
+		part.FileId = msg.Audio.FileId
+	default:
+		return nil, false
+	}
+	return part, true
+}
+
+// relayAlbumPart buffers one part of an album and sends the album on once
+// all of its parts have arrived.
+func relayAlbumPart(ctx context.Context, m *OutgoingMessage) error {
+	user := m.Sender
+	part, ok := albumPart(m.Msg)
+	if !ok {
+		return relayCopy(ctx, m)
+	}
+	part.Caption = m.Text
+
+	if err := userStore.SaveMediaGroupPart(ctx, part); err != nil {
+		return err
+	}
+
+	claimed, done, err := userStore.ClaimMediaGroup(ctx, part.GroupId)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		if !done {
+			// The invocation with the first part sends this one with it.
+			return nil
+		}
+		taken, err := userStore.TakeMediaGroupPart(ctx, part.GroupId, part.MessageId)
+		if err != nil || !taken {
+			return err
+		}
+		log.Printf("LOG: Part %d of album %s arrived after it was sent, relaying it alone.", part.MessageId, part.GroupId)
+		return relayCopy(ctx, m)
+	}
+
+	time.Sleep(albumWait)
+
+	if err := userStore.CloseMediaGroup(ctx, part.GroupId); err != nil {
+		return err
+	}
+	stored, err := userStore.GetMediaGroupParts(ctx, part.GroupId)
+	if err != nil {
+		return err
+	}
+	var parts []store.MediaGroupPart
+	for _, p := range stored {
+		if p.MessageId != part.MessageId {
+			// A part is taken only once, by this invocation or its own.
+			taken, err := userStore.TakeMediaGroupPart(ctx, p.GroupId, p.MessageId)
+			if err != nil {
+				log.Printf("ERROR: Failed to take part %d of album %s: %v", p.MessageId, p.GroupId, err)
+				continue
+			}
+			if !taken {
+				continue
+			}
+		}
+		parts = append(parts, p)
+	}
+
+	if len(parts) < 2 {
+		// Nothing else arrived in time, and an album needs two parts.
+		return relayCopy(ctx, m)
+	}
+	return sendAlbum(ctx, user, parts)
+}
+
+func sendAlbum(ctx context.Context, user *store.User, parts []store.MediaGroupPart) error {
+	opts := relayOptionsFor(user)
+	media := make([]tgx.InputMedia, len(parts))
+	viewOnce := false
+	for i, part := range parts {
+		spoiler := opts.Spoiler && (part.Type == "photo" || part.Type == "video")
+		viewOnce = viewOnce || spoiler
+		media[i] = tgx.InputMedia{Type: part.Type, Media: part.FileId, Caption: part.Caption, HasSpoiler: spoiler}
+	}
+
+	copyIds, err := sendMediaGroup(user.Partner, media, opts)
+	if err != nil {
+		return err
+	}
+	log.Printf("LOG: Relayed album of %d items from %d to %d.", len(parts), user.ChatId, user.Partner)
+
+	for i, copyId := range copyIds {
+		if i >= len(parts) {
+			break
+		}
+		saveRelayedMessage(ctx, user, parts[i].MessageId, copyId, media[i].HasSpoiler)
+	}
+	if viewOnce {
+		markViewOncePending(ctx, user.Partner)
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"github.com/harshyadavone/anonymous_chat/store"
+)
+
+type dynamoItem map[string]map[string]any
+
+// fakeMediaGroups keeps the media groups table in memory and answers the
+// requests the album relay makes. Writes to any other table are accepted
+// and dropped.
+type fakeMediaGroups struct {
+	mu    sync.Mutex
+	items map[string]dynamoItem
+}
+
+func (f *fakeMediaGroups) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Item                      dynamoItem
+		Key                       dynamoItem
+		ConditionExpression       string
+		ExpressionAttributeValues dynamoItem
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key := func(item dynamoItem) string {
+		return fmt.Sprint(item["GroupId"]["S"], "/", item["MessageId"]["N"])
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	var resp any = map[string]any{}
+	switch op := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810."); op {
+	case "PutItem":
+		if _, exists := f.items[key(req.Item)]; exists && req.ConditionExpression == "attribute_not_exists(GroupId)" {
+			conditionFailed(w)
+			return
+		}
+		f.items[key(req.Item)] = req.Item
+	case "GetItem":
+		resp = map[string]any{"Item": f.items[key(req.Key)]}
+	case "UpdateItem":
+		f.items[key(req.Key)]["Done"] = req.ExpressionAttributeValues[":done"]
+	case "DeleteItem":
+		if _, exists := f.items[key(req.Key)]; !exists {
+			conditionFailed(w)
+			return
+		}
+		delete(f.items, key(req.Key))
+	case "Query":
+		group := req.ExpressionAttributeValues[":group"]["S"]
+		var items []dynamoItem
+		for _, item := range f.items {
+			if item["GroupId"]["S"] == group && messageId(item) > 0 {
+				items = append(items, item)
+			}
+		}
+		sort.Slice(items, func(i, j int) bool { return messageId(items[i]) < messageId(items[j]) })
+		resp = map[string]any{"Items": items, "Count": len(items)}
+	case "BatchWriteItem":
+	default:
+		http.Error(w, "unexpected "+op, http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func messageId(item dynamoItem) int64 {
+	n, _ := strconv.ParseInt(fmt.Sprint(item["MessageId"]["N"]), 10, 64)
+	return n
+}
+
+func conditionFailed(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]any{
+		"__type":  "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException",
+		"message": "The conditional request failed",
+	})
+}
+
+// fakeTelegram records which of the sender's messages every request
+// delivered. File IDs in the tests are the IDs of the messages they came in.
+type fakeTelegram struct {
+	mu   sync.Mutex
+	sent [][]int64
+}
+
+func (f *fakeTelegram) RoundTrip(r *http.Request) (*http.Response, error) {
+	var params struct {
+		MessageId int64 `json:"message_id"`
+		Media     []struct {
+			Media string `json:"media"`
+		} `json:"media"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		return nil, err
+	}
+
+	var delivered []int64
+	var result any
+	switch path.Base(r.URL.Path) {
+	case "copyMessage":
+		delivered = []int64{params.MessageId}
+		result = map[string]any{"message_id": 100 + params.MessageId}
+	case "sendMediaGroup":
+		var sent []map[string]any
+		for _, media := range params.Media {
+			id, _ := strconv.ParseInt(media.Media, 10, 64)
+			delivered = append(delivered, id)
+			sent = append(sent, map[string]any{"message_id": 100 + id})
+		}
+		result = sent
+	default:
+		return nil, fmt.Errorf("unexpected request %s", r.URL.Path)
+	}
+
+	f.mu.Lock()
+	f.sent = append(f.sent, delivered)
+	f.mu.Unlock()
+
+	body, _ := json.Marshal(map[string]any{"ok": true, "result": result})
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(string(body))),
+		Request:    r,
+	}, nil
+}
+
+func TestRelayAlbumPart(t *testing.T) {
+	defer func(saved *store.DynamoDBStore) { userStore = saved }(userStore)
+	defer func(saved *http.Client) { apiClient = saved }(apiClient)
+
+	sender := &store.User{ChatId: 1, Partner: 2, IsConnected: true, SessionId: "session"}
+	album := func() []*OutgoingMessage {
+		var parts []*OutgoingMessage
+		for id := 1; id <= 3; id++ {
+			var msg Message
+			data := fmt.Sprintf(`{"message_id":%d,"chat":{"id":1},"media_group_id":"album","photo":[{"file_id":"%d"}]}`, id, id)
+			if err := json.Unmarshal([]byte(data), &msg); err != nil {
+				t.Fatal(err)
+			}
+			parts = append(parts, newOutgoingMessage(sender, &msg))
+		}
+		return parts
+	}
+
+	tests := []struct {
+		name    string
+		deliver func(parts []*OutgoingMessage)
+		want    [][]int64
+	}{
+		{
+			name: "parts at once",
+			deliver: func(parts []*OutgoingMessage) {
+				var wg sync.WaitGroup
+				for _, m := range parts {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						if err := relayAlbumPart(context.Background(), m); err != nil {
+							t.Errorf("relayAlbumPart(%d) error = %v", m.Msg.MessageId, err)
+						}
+					}()
+				}
+				wg.Wait()
+			},
+			want: [][]int64{{1, 2, 3}},
+		},
+		{
+			// Telegram waits for each update of a chat before sending the
+			// next one, so the first part can't wait for the others.
+			name: "parts one at a time",
+			deliver: func(parts []*OutgoingMessage) {
+				for _, m := range parts {
+					if err := relayAlbumPart(context.Background(), m); err != nil {
+						t.Errorf("relayAlbumPart(%d) error = %v", m.Msg.MessageId, err)
+					}
+				}
+			},
+			want: [][]int64{{1}, {2}, {3}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(&fakeMediaGroups{items: make(map[string]dynamoItem)})
+			defer server.Close()
+			userStore = &store.DynamoDBStore{
+				Client: dynamodb.New(dynamodb.Options{
+					BaseEndpoint: aws.String(server.URL),
+					Region:       "us-east-1",
+					Credentials:  aws.AnonymousCredentials{},
+					Retryer:      aws.NopRetryer{},
+				}),
+				MediaGroupsTableName: "groups",
+				MessagesTableName:    "messages",
+			}
+			telegram := &fakeTelegram{}
+			apiClient = &http.Client{Transport: telegram}
+
+			start := time.Now()
+			tt.deliver(album())
+			if elapsed := time.Since(start); elapsed > 2*albumWait {
+				t.Errorf("relaying the album took %v, want only the first part to wait %v", elapsed, albumWait)
+			}
+			if !reflect.DeepEqual(telegram.sent, tt.want) {
+				t.Errorf("delivered %v, want %v", telegram.sent, tt.want)
+			}
+		})
+	}
+}
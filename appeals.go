@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/harshyadavone/anonymous_chat/store"
+	"github.com/harshyadavone/tgx"
+	"github.com/harshyadavone/tgx/models"
+)
+
+const maxAppealLength = 500
+
+// HandleAppeal sends a banned user's statement to the admin chats. A user
+// can have one appeal pending at a time and has to wait
+// config.AppealCooldown after a rejected one.
+func HandleAppeal(ctx *tgx.Context) error {
+	chatId := ctx.ChatID
+	bg := context.Background()
+
+	user, err := GetUser(bg, chatId)
+	if err != nil || !user.IsBanned(time.Now()) {
+		return ctx.Reply(MessageAppealNotBanned)
+	}
+
+	switch user.AppealStatus {
+	case store.AppealPending:
+		return ctx.Reply(MessageAppealPending)
+	case store.AppealRejected:
+		next := time.Unix(user.AppealedAt, 0).Add(config.AppealCooldown)
+		if time.Now().Before(next) {
+			return ctx.Reply(fmt.Sprintf(MessageAppealTooSoon, next.UTC().Format(banTimeLayout)))
+		}
+	}
+
+	statement := strings.Join(ctx.Args, " ")
+	if statement == "" || utf8.RuneCountInString(statement) > maxAppealLength {
+		return ctx.Reply(fmt.Sprintf(MessageAppealUsage, maxAppealLength))
+	}
+
+	user.AppealStatus = store.AppealPending
+	user.AppealText = statement
+	user.AppealedAt = time.Now().Unix()
+	user.AppealDecidedBy = 0
+	if err := UpdateUser(bg, user); err != nil {
+		log.Printf("ERROR: Failed to save appeal of user %d: %v", chatId, err)
+		return ctx.Reply(MessageErrSomethingWentWrong)
+	}
+
+	log.Printf("LOG: User %d appealed their ban.", chatId)
+	id := strconv.FormatInt(chatId, 10)
+	for _, adminChatId := range config.AdminChatIds {
+		err := bot.SendMessageWithOpts(&tgx.SendMessageRequest{
+			ChatId: adminChatId,
+			Text:   fmt.Sprintf(MessageAdminAppeal, chatId, user.BanCount, banEnd(user), reportScore(user), statement),
+			ReplyMarkup: models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{{
+				{Text: "Accept", CallbackData: CallbackAppealAccept + id},
+				{Text: "Reject", CallbackData: CallbackAppealReject + id},
+			}}},
+		})
+		if err != nil {
+			log.Printf("ERROR: Failed to send appeal of user %d to admin chat %d: %v", chatId, adminChatId, err)
+		}
+	}
+	return ctx.Reply(MessageAppealSent)
+}
+
+// HandleAppealDecision accepts or rejects an appeal from its buttons. An
+// accepted appeal lifts the ban.
+func HandleAppealDecision(b *tgx.Bot, query *CallbackQuery) error {
+	if query.Message == nil || (!isAdmin(query.Message.Chat.Id) && !isAdmin(query.From.Id)) {
+		log.Printf("WARN: User %d pressed an admin button outside an admin chat.", query.From.Id)
+		return answerCallbackQuery(query.Id, "", false)
+	}
+
+	accept := strings.HasPrefix(query.Data, CallbackAppealAccept)
+	id := strings.TrimPrefix(strings.TrimPrefix(query.Data, CallbackAppealAccept), CallbackAppealReject)
+	chatId, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid user in callback data %q", query.Data)
+	}
+
+	bg := context.Background()
+	user, err := GetUser(bg, chatId)
+	if err != nil {
+		return err
+	}
+	if user.AppealStatus != store.AppealPending {
+		return answerCallbackQuery(query.Id, MessageAdminAppealDecided, true)
+	}
+
+	user.AppealDecidedBy = query.From.Id
+	user.AppealStatus = store.AppealRejected
+	notice := MessageAppealRejected
+	if accept {
+		user.AppealStatus = store.AppealAccepted
+		notice = MessageAppealAccepted
+		unbanUser(user)
+	}
+	if err := UpdateUser(bg, user); err != nil {
+		return err
+	}
+	log.Printf("LOG: Admin %d %s the appeal of user %d.", query.From.Id, user.AppealStatus, chatId)
+	b.SendMessage(chatId, notice)
+
+	if query.Message.Text != "" {
+		text := query.Message.Text + "\n\n" + fmt.Sprintf(MessageAdminDecided, user.AppealStatus, query.From.Id)
+		if err := editMessageText(query.Message.Chat.Id, query.Message.MessageId, text); err != nil {
+			log.Printf("WARN: Failed to update appeal message in chat %d: %v", query.Message.Chat.Id, err)
+		}
+	}
+	return answerCallbackQuery(query.Id, "", false)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/harshyadavone/anonymous_chat/store"
+	"github.com/harshyadavone/tgx"
+)
+
+const banTimeLayout = "2 Jan 2006 15:04 MST"
+
+// applyBanPolicy bans a user whose report score went from before to after,
+// if that crossed one of config.BanThresholds. The ban lasts as long as the
+// duration for the highest threshold crossed, or longer for users who were
+// banned before, and becomes permanent after config.PermanentBanAfter bans.
+// It reports whether the user was banned.
+func applyBanPolicy(user *store.User, before, after float64) bool {
+	tier := -1
+	for i, threshold := range config.BanThresholds {
+		if before < threshold && after >= threshold {
+			tier = i
+		}
+	}
+	if tier < 0 || len(config.BanDurations) == 0 {
+		return false
+	}
+
+	banUser(user, banDuration(user, tier))
+	log.Printf("LOG: User %d banned after their report score reached %.2f. Ban %d, permanent: %t.",
+		user.ChatId, after, user.BanCount, user.BanPermanent)
+	return true
+}
+
+// banDuration returns how long the next ban of a user lasts: the duration of
+// the given tier, or of their ban count if that is higher, and 0 for a
+// permanent ban once they reach config.PermanentBanAfter bans or when no
+// durations are configured.
+func banDuration(user *store.User, tier int) time.Duration {
+	if len(config.BanDurations) == 0 || (config.PermanentBanAfter > 0 && user.BanCount+1 >= config.PermanentBanAfter) {
+		return 0
+	}
+	level := max(tier, user.BanCount)
+	return config.BanDurations[min(level, len(config.BanDurations)-1)]
+}
+
+// banUser bans a user for the given duration, or permanently if it is 0.
+// The caller saves the user.
+func banUser(user *store.User, duration time.Duration) {
+	user.BanCount++
+	label := "temporary"
+	if duration <= 0 {
+		label = "permanent"
+	}
+	if err := userStore.IncrementMetric(context.Background(), "bans", label); err != nil {
+		log.Printf("WARN: Failed to count ban: %v", err)
+	}
+	if duration <= 0 {
+		user.BanPermanent = true
+		return
+	}
+	until := time.Now().Add(duration).Unix()
+	user.BannedUntil = max(user.BannedUntil, until)
+}
+
+// unbanUser lifts any ban. The caller saves the user.
+func unbanUser(user *store.User) {
+	user.BannedUntil = 0
+	user.BanPermanent = false
+}
+
+// banMessage tells a banned user until when they are banned and how to
+// appeal.
+func banMessage(user *store.User) string {
+	text := MessageBannedPermanently
+	if !user.BanPermanent {
+		text = fmt.Sprintf(MessageBanned, time.Unix(user.BannedUntil, 0).UTC().Format(banTimeLayout))
+	}
+	if len(config.AdminChatIds) > 0 {
+		text += "\n\n" + MessageBannedAppeal
+	}
+	return text
+}
+
+// enforceBan saves a user who was just banned, takes them out of the queue
+// and ends their chat, and tells them about the ban.
+func enforceBan(b *tgx.Bot, user *store.User) error {
+	ctx := context.Background()
+	user.IsConnecting = 0
+	if err := UpdateUser(ctx, user); err != nil {
+		return err
+	}
+	if user.IsConnected {
+		HandleStop(b, user.ChatId)
+	}
+	return b.SendMessage(user.ChatId, banMessage(user))
+}
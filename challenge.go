@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/harshyadavone/anonymous_chat/store"
+	"github.com/harshyadavone/tgx"
+	"github.com/harshyadavone/tgx/models"
+)
+
+// challengeEmoji is one of the pictures a new user picks from to show they
+// are not a bot.
+type challengeEmoji struct {
+	Emoji string
+	Name  string
+}
+
+var challengeEmojis = []challengeEmoji{
+	{"🐶", "dog"},
+	{"🍎", "apple"},
+	{"🚗", "car"},
+	{"⚽", "football"},
+	{"🌙", "moon"},
+	{"🎸", "guitar"},
+	{"🌵", "cactus"},
+	{"🍕", "pizza"},
+}
+
+// How many pictures a challenge shows.
+const challengeChoices = 6
+
+// needsChallenge reports whether a user has to pass the challenge before
+// they can queue. Users saved before the challenge existed skip it.
+func needsChallenge(user *store.User) bool {
+	return !user.ChallengePassed && !user.Grandfathered
+}
+
+// challengeBlocked reports whether a user failed the challenge too often and
+// has to wait before trying again.
+func challengeBlocked(user *store.User, now time.Time) bool {
+	return user.ChallengeBlockedUntil > now.Unix()
+}
+
+// sendChallenge asks the user to pick a picture by its name, and keeps the
+// expected answer on the user. Every challenge gets a new ID, which its
+// buttons carry, so answers from an older keyboard are not checked against
+// the newest answer. The caller saves the user.
+func sendChallenge(b *tgx.Bot, user *store.User) error {
+	picks := rand.Perm(len(challengeEmojis))[:challengeChoices]
+	answer := picks[rand.Intn(len(picks))]
+	user.ChallengeAnswer = strconv.Itoa(answer)
+	user.ChallengeId = strconv.FormatUint(rand.Uint64(), 36)
+
+	var row []models.InlineKeyboardButton
+	var keyboard [][]models.InlineKeyboardButton
+	for _, i := range picks {
+		row = append(row, models.InlineKeyboardButton{
+			Text:         challengeEmojis[i].Emoji,
+			CallbackData: CallbackChallengePrefix + user.ChallengeId + ":" + strconv.Itoa(i),
+		})
+		if len(row) == challengeChoices/2 {
+			keyboard = append(keyboard, row)
+			row = nil
+		}
+	}
+
+	return b.SendMessageWithOpts(&tgx.SendMessageRequest{
+		ChatId:      user.ChatId,
+		Text:        fmt.Sprintf(MessageChallenge, challengeEmojis[answer].Name),
+		ReplyMarkup: models.InlineKeyboardMarkup{InlineKeyboard: keyboard},
+	})
+}
+
+// challengeBlockMessage tells a user until when they can't try the
+// challenge again.
+func challengeBlockMessage(user *store.User) string {
+	return fmt.Sprintf(MessageChallengeBlocked, time.Unix(user.ChallengeBlockedUntil, 0).UTC().Format(banTimeLayout))
+}
+
+// HandleChallengeAnswer checks the picture a user picked. Users who pass go
+// straight on to look for a partner. Every config.ChallengeMaxFailures wrong
+// answers block the user for config.ChallengeCooldown times the number of
+// blocks so far.
+func HandleChallengeAnswer(b *tgx.Bot, query *CallbackQuery) error {
+	if query.Message == nil {
+		return answerCallbackQuery(query.Id, "", false)
+	}
+	chatId, messageId := query.Message.Chat.Id, query.Message.MessageId
+	bg := context.Background()
+	now := time.Now()
+
+	challengeId, choice, _ := strings.Cut(strings.TrimPrefix(query.Data, CallbackChallengePrefix), ":")
+	user, err := GetUser(bg, chatId)
+	if err != nil || user.ChallengeAnswer == "" || user.ChallengeId != challengeId || challengeBlocked(user, now) {
+		editMessageText(chatId, messageId, MessageChallengeExpired)
+		return answerCallbackQuery(query.Id, "", false)
+	}
+
+	passed := user.ChallengeAnswer == choice
+	user.ChallengeAnswer = ""
+	user.ChallengeId = ""
+	text := MessageChallengePassed
+	if passed {
+		log.Printf("LOG: User %d passed the challenge.", chatId)
+		user.ChallengePassed = true
+	} else {
+		user.ChallengeFailures++
+		log.Printf("LOG: User %d failed the challenge, %d failures.", chatId, user.ChallengeFailures)
+		text = MessageChallengeFailed
+		if limit := config.ChallengeMaxFailures; limit > 0 && user.ChallengeFailures%limit == 0 {
+			blocks := user.ChallengeFailures / limit
+			user.ChallengeBlockedUntil = now.Add(time.Duration(blocks) * config.ChallengeCooldown).Unix()
+			log.Printf("WARN: User %d blocked from the challenge until %d.", chatId, user.ChallengeBlockedUntil)
+			text = challengeBlockMessage(user)
+		}
+	}
+
+	if err := editMessageText(chatId, messageId, text); err != nil {
+		log.Printf("ERROR: Failed to edit message text for user %d: %v", chatId, err)
+	}
+	if !passed && !challengeBlocked(user, now) {
+		if err := sendChallenge(b, user); err != nil {
+			log.Printf("ERROR: Failed to send a new challenge to user %d: %v", chatId, err)
+		}
+	}
+	if err := UpdateUser(bg, user); err != nil {
+		log.Printf("ERROR: Failed to save challenge answer of user %d: %v", chatId, err)
+		return answerCallbackQuery(query.Id, MessageErrSomethingWentWrong, true)
+	}
+	answerCallbackQuery(query.Id, "", false)
+
+	if passed {
+		return HandleConnect(b, chatId)
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/harshyadavone/anonymous_chat/store"
+)
+
+// Config holds the per-deployment settings read from the environment.
+type Config struct {
+	// RelayFilters lists the relay filters to run, in order.
+	RelayFilters        []string
+	MaxMessageLength    int
+	BlockedContentTypes []string
+	PIIPolicy           string
+
+	// WordListDir holds <language>.txt word lists for the profanity filter.
+	// The lists built into the binary are used when it is empty.
+	WordListDir       string
+	WordListLanguages []string
+	ProfanityAction   string
+	// ProfanityReportEvery turns every Nth profanity offence of a user into
+	// a report against them.
+	ProfanityReportEvery int
+
+	// Flood control budgets for relayed messages, per user.
+	TextRateLimit  store.RateLimit
+	MediaRateLimit store.RateLimit
+
+	// EvidenceChatId is a chat the bot copies reported messages into for
+	// moderators. Evidence is not kept when it is 0.
+	EvidenceChatId         int64
+	ReportEvidenceMessages int
+	// ReportHalfLife is how long it takes for the weight of reports against
+	// a user to halve.
+	ReportHalfLife time.Duration
+
+	// A user is banned for BanDurations[i] when their report score reaches
+	// BanThresholds[i], and for good after PermanentBanAfter bans.
+	BanThresholds     []float64
+	BanDurations      []time.Duration
+	PermanentBanAfter int
+
+	// Users whose report score reaches ShadowPoolScore are only matched with
+	// each other for ShadowPoolDuration after their latest report.
+	ShadowPoolScore    float64
+	ShadowPoolDuration time.Duration
+
+	// AdminChatIds are the chats allowed to use the moderation commands.
+	// New reports are sent to all of them.
+	AdminChatIds []int64
+	// AppealCooldown is how long a user has to wait between ban appeals.
+	AppealCooldown time.Duration
+
+	// RulesVersion is the version of the community rules in MessageRules.
+	// Raising it asks every user to accept the rules again before their next
+	// chat.
+	RulesVersion int
+
+	// New users who pick the wrong picture ChallengeMaxFailures times in a
+	// row wait ChallengeCooldown, longer every time, before trying again.
+	ChallengeMaxFailures int
+	ChallengeCooldown    time.Duration
+
+	// The first SpamFirstMessages messages of every session are fingerprinted
+	// if they are at least SpamMinLength characters long. A user who sends the
+	// same one to SpamPartners partners within SpamWindow gets SpamAction.
+	SpamFirstMessages int
+	SpamMinLength     int
+	SpamPartners      int
+	SpamWindow        time.Duration
+	SpamAction        string
+
+	// A user reaches trust level i+1 once they had TrustSessions[i] chats and
+	// first used the bot TrustAgeDays[i] days ago. Every point of report
+	// score, and a rating below TrustMinRating once they have at least
+	// TrustMinRatings ratings, takes a level off.
+	TrustSessions   []int
+	TrustAgeDays    []int
+	TrustMinRating  float64
+	TrustMinRatings int
+
+	// CrisisDetection sends users who write crisis phrases helpline
+	// resources. CrisisListDir and CrisisLanguages work like WordListDir and
+	// WordListLanguages.
+	CrisisDetection bool
+	CrisisListDir   string
+	CrisisLanguages []string
+}
+
+func loadConfig() Config {
+	return Config{
+		RelayFilters:        envList("RELAY_FILTERS", []string{"types", "maxlength", "pii", "profanity"}),
+		MaxMessageLength:    envInt("MAX_MESSAGE_LENGTH", 2000),
+		BlockedContentTypes: envList("BLOCKED_CONTENT_TYPES", nil),
+		PIIPolicy:           parsePIIPolicy(envString("PII_POLICY", PIIPolicyWarn)),
+
+		WordListDir:          os.Getenv("WORDLIST_DIR"),
+		WordListLanguages:    envList("WORDLIST_LANGUAGES", nil),
+		ProfanityAction:      parseProfanityAction(envString("PROFANITY_ACTION", ProfanityActionMask)),
+		ProfanityReportEvery: envInt("PROFANITY_REPORT_EVERY", 5),
+
+		TextRateLimit: store.RateLimit{
+			Burst:     float64(envInt("FLOOD_TEXT_BURST", 20)),
+			PerMinute: float64(envInt("FLOOD_TEXT_PER_MINUTE", 30)),
+		},
+		MediaRateLimit: store.RateLimit{
+			Burst:     float64(envInt("FLOOD_MEDIA_BURST", 5)),
+			PerMinute: float64(envInt("FLOOD_MEDIA_PER_MINUTE", 10)),
+		},
+
+		EvidenceChatId:         int64(envInt("EVIDENCE_CHAT_ID", 0)),
+		ReportEvidenceMessages: envInt("REPORT_EVIDENCE_MESSAGES", 5),
+		ReportHalfLife:         time.Duration(envInt("REPORT_HALF_LIFE_DAYS", 30)) * 24 * time.Hour,
+
+		BanThresholds:     envFloats("BAN_THRESHOLDS", []float64{3, 6, 10}),
+		BanDurations:      envDurations("BAN_DURATIONS", []time.Duration{24 * time.Hour, 72 * time.Hour, 7 * 24 * time.Hour}),
+		PermanentBanAfter: envInt("PERMANENT_BAN_AFTER", 4),
+
+		ShadowPoolScore:    envFloat("SHADOW_POOL_SCORE", 2),
+		ShadowPoolDuration: time.Duration(envInt("SHADOW_POOL_HOURS", 72)) * time.Hour,
+
+		AdminChatIds:   envInt64s("ADMIN_CHAT_IDS"),
+		AppealCooldown: time.Duration(envInt("APPEAL_COOLDOWN_HOURS", 72)) * time.Hour,
+
+		RulesVersion: envInt("RULES_VERSION", 1),
+
+		ChallengeMaxFailures: envInt("CHALLENGE_MAX_FAILURES", 3),
+		ChallengeCooldown:    time.Duration(envInt("CHALLENGE_COOLDOWN_MINUTES", 30)) * time.Minute,
+
+		SpamFirstMessages: envInt("SPAM_FIRST_MESSAGES", 3),
+		SpamMinLength:     envInt("SPAM_MIN_LENGTH", 15),
+		SpamPartners:      envInt("SPAM_PARTNERS", 5),
+		SpamWindow:        time.Duration(envInt("SPAM_WINDOW_MINUTES", 60)) * time.Minute,
+		SpamAction:        parseSpamAction(envString("SPAM_ACTION", SpamActionReport)),
+
+		TrustSessions:   envInts("TRUST_SESSIONS", []int{3, 10, 30}),
+		TrustAgeDays:    envInts("TRUST_AGE_DAYS", []int{0, 2, 7}),
+		TrustMinRating:  envFloat("TRUST_MIN_RATING", 0.4),
+		TrustMinRatings: envInt("TRUST_MIN_RATINGS", 5),
+
+		CrisisDetection: envBool("CRISIS_DETECTION", false),
+		CrisisListDir:   os.Getenv("CRISIS_LIST_DIR"),
+		CrisisLanguages: envList("CRISIS_LANGUAGES", nil),
+	}
+}
+
+func envString(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// envList reads a comma separated list, ignoring empty entries.
+func envList(key string, fallback []string) []string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func envInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("WARN: Invalid value %q for %s, using %d", value, key, fallback)
+		return fallback
+	}
+	return n
+}
+
+func envBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("WARN: Invalid value %q for %s, using %t", value, key, fallback)
+		return fallback
+	}
+	return b
+}
+
+func envFloat(key string, fallback float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("WARN: Invalid value %q for %s, using %v", value, key, fallback)
+		return fallback
+	}
+	return n
+}
+
+func envFloats(key string, fallback []float64) []float64 {
+	var values []float64
+	for _, item := range envList(key, nil) {
+		n, err := strconv.ParseFloat(item, 64)
+		if err != nil {
+			log.Printf("WARN: Invalid value %q for %s, using defaults", item, key)
+			return fallback
+		}
+		values = append(values, n)
+	}
+	if values == nil {
+		return fallback
+	}
+	return values
+}
+
+func envInts(key string, fallback []int) []int {
+	var values []int
+	for _, item := range envList(key, nil) {
+		n, err := strconv.Atoi(item)
+		if err != nil {
+			log.Printf("WARN: Invalid value %q for %s, using defaults", item, key)
+			return fallback
+		}
+		values = append(values, n)
+	}
+	if values == nil {
+		return fallback
+	}
+	return values
+}
+
+func envDurations(key string, fallback []time.Duration) []time.Duration {
+	var values []time.Duration
+	for _, item := range envList(key, nil) {
+		d, err := time.ParseDuration(item)
+		if err != nil {
+			log.Printf("WARN: Invalid value %q for %s, using defaults", item, key)
+			return fallback
+		}
+		values = append(values, d)
+	}
+	if values == nil {
+		return fallback
+	}
+	return values
+}
+
+func envInt64s(key string) []int64 {
+	var values []int64
+	for _, item := range envList(key, nil) {
+		n, err := strconv.ParseInt(item, 10, 64)
+		if err != nil {
+			log.Printf("WARN: Invalid value %q for %s, skipping", item, key)
+			continue
+		}
+		values = append(values, n)
+	}
+	return values
+}
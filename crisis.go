@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/harshyadavone/anonymous_chat/store"
+)
+
+//go:embed crisis/*.txt
+var defaultCrisisLists embed.FS
+
+// crisisList holds the crisis phrases of one language and the helpline
+// resources sent to someone who uses them.
+type crisisList struct {
+	Language  string
+	Phrases   []wordListEntry
+	Resources string
+}
+
+// crisisLists is empty unless CRISIS_DETECTION is on.
+var crisisLists []crisisList
+
+// findCrisis returns the list whose phrases the text uses, or nil. Phrases
+// are matched the same way as the profanity word lists.
+func findCrisis(text string) *crisisList {
+	for i := range crisisLists {
+		if len(findProfanity(text, crisisLists[i].Phrases)) > 0 {
+			return &crisisLists[i]
+		}
+	}
+	return nil
+}
+
+// checkCrisis privately sends the sender helpline resources in the language
+// of the phrase they used, once per session. The message itself is relayed
+// as usual and the partner is not told. Every occurrence is counted by
+// language only.
+func checkCrisis(ctx context.Context, user *store.User, text string) {
+	if len(crisisLists) == 0 || text == "" {
+		return
+	}
+	list := findCrisis(text)
+	if list == nil {
+		return
+	}
+
+	if err := userStore.IncrementMetric(ctx, "crisis", list.Language); err != nil {
+		log.Printf("WARN: Failed to count crisis message: %v", err)
+	}
+	if user.CrisisNoticeSession == user.SessionId {
+		return
+	}
+	user.CrisisNoticeSession = user.SessionId
+	if err := UpdateUser(ctx, user); err != nil {
+		log.Printf("WARN: Failed to save crisis notice of user %d: %v", user.ChatId, err)
+	}
+	if err := bot.SendMessage(user.ChatId, list.Resources); err != nil {
+		log.Printf("ERROR: Failed to send crisis resources to user %d: %v", user.ChatId, err)
+	}
+}
+
+// loadCrisisLists reads <language>.txt for each language from dir, or from
+// the lists built into the binary when dir is empty, like loadWordLists.
+// Lists without resources are skipped.
+func loadCrisisLists(dir string, languages []string) []crisisList {
+	var fsys fs.FS = defaultCrisisLists
+	root := "crisis"
+	if dir != "" {
+		fsys, root = os.DirFS(dir), "."
+	}
+
+	if len(languages) == 0 {
+		paths, err := fs.Glob(fsys, path.Join(root, "*.txt"))
+		if err != nil {
+			log.Printf("ERROR: Failed to list crisis phrase lists: %v", err)
+		}
+		for _, p := range paths {
+			languages = append(languages, strings.TrimSuffix(path.Base(p), ".txt"))
+		}
+	}
+
+	var lists []crisisList
+	for _, language := range languages {
+		f, err := fsys.Open(path.Join(root, language+".txt"))
+		if err != nil {
+			log.Printf("WARN: No crisis phrase list for language %q: %v", language, err)
+			continue
+		}
+		list, err := parseCrisisList(f)
+		f.Close()
+		if err != nil {
+			log.Printf("ERROR: Failed to read crisis phrase list %q: %v", language, err)
+			continue
+		}
+		if list.Resources == "" {
+			log.Printf("WARN: Crisis phrase list %q has no resources, skipping", language)
+			continue
+		}
+		list.Language = language
+		lists = append(lists, *list)
+	}
+	return lists
+}
+
+// parseCrisisList reads phrases one per line. Lines starting with > make up
+// the resources message, and lines starting with # are ignored.
+func parseCrisisList(r io.Reader) (*crisisList, error) {
+	var list crisisList
+	var resources []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, ">"):
+			resources = append(resources, strings.TrimSpace(strings.TrimPrefix(line, ">")))
+			continue
+		}
+		var entry wordListEntry
+		for _, t := range tokenize(line) {
+			entry = append(entry, t.word)
+		}
+		if len(entry) > 0 {
+			list.Phrases = append(list.Phrases, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan crisis phrase list: %w", err)
+	}
+	list.Resources = strings.Join(resources, "\n")
+	return &list, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindCrisis(t *testing.T) {
+	list, err := parseCrisisList(strings.NewReader("# Hindi\n> मदद\nआत्महत्या\nमरना चाहता हूं\n"))
+	if err != nil {
+		t.Fatalf("parseCrisisList() error = %v", err)
+	}
+	if list.Resources != "मदद" {
+		t.Errorf("resources = %q, want %q", list.Resources, "मदद")
+	}
+	list.Language = "hi"
+
+	defer func(saved []crisisList) { crisisLists = saved }(crisisLists)
+	crisisLists = []crisisList{*list}
+
+	tests := []struct {
+		name string
+		text string
+		want bool
+	}{
+		{"word", "मैं आत्महत्या के बारे में सोच रहा हूं", true},
+		{"phrase", "मैं मरना चाहता हूं", true},
+		{"part of the word", "हत्या", false},
+		{"unrelated", "मैं ठीक हूँ", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findCrisis(tt.text); (got != nil) != tt.want {
+				t.Errorf("findCrisis(%q) = %v, want a match: %t", tt.text, got, tt.want)
+			}
+		})
+	}
+}
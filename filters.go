@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/harshyadavone/anonymous_chat/store"
+)
+
+type FilterAction int
+
+const (
+	// FilterPass delivers the message, possibly after the filter changed it.
+	FilterPass FilterAction = iota
+	// FilterFlag delivers the message but records it against the sender.
+	FilterFlag
+	// FilterBlock drops the message.
+	FilterBlock
+	// FilterHold keeps the message back until the sender confirms it.
+	FilterHold
+)
+
+type FilterResult struct {
+	Action FilterAction
+	Reason string // recorded when the message is flagged or blocked
+	Notice string // sent privately to the sender
+}
+
+// OutgoingMessage is a message on its way to the partner. Filters may change
+// Text, which is the text of a text message or the caption of any other.
+// Confirmed is set once the sender confirmed a message a filter held.
+type OutgoingMessage struct {
+	Sender    *store.User
+	Msg       *Message
+	Kind      string
+	Text      string
+	Entities  []MessageEntity
+	Modified  bool
+	Confirmed bool
+}
+
+// RelayFilter inspects a message before it is delivered.
+type RelayFilter func(m *OutgoingMessage) FilterResult
+
+// relayFilterFactories are the built-in filters by the name used in
+// RELAY_FILTERS.
+var relayFilterFactories = map[string]func(cfg Config) RelayFilter{
+	"links":     func(Config) RelayFilter { return linkFilter },
+	"maxlength": maxLengthFilter,
+	"pii":       piiFilter,
+	"profanity": profanityFilter,
+	"types":     contentTypeFilter,
+}
+
+// buildRelayFilters turns the configured filter names into the filter chain.
+func buildRelayFilters(cfg Config) []RelayFilter {
+	var filters []RelayFilter
+	for _, name := range cfg.RelayFilters {
+		factory, ok := relayFilterFactories[name]
+		if !ok {
+			log.Printf("WARN: Unknown relay filter %q, skipping", name)
+			continue
+		}
+		filters = append(filters, factory(cfg))
+	}
+	return filters
+}
+
+func newOutgoingMessage(sender *store.User, msg *Message) *OutgoingMessage {
+	m := &OutgoingMessage{Sender: sender, Msg: msg, Kind: messageKind(msg)}
+	if m.Kind == "Text" {
+		m.Text, m.Entities = msg.Text, msg.Entities
+	} else {
+		m.Text, m.Entities = msg.Caption, msg.CaptionEntities
+	}
+	return m
+}
+
+// applyRelayFilters runs the filter chain in order until a filter blocks or
+// holds the message, and reports whether the message should still be
+// delivered.
+func applyRelayFilters(m *OutgoingMessage) ([]FilterResult, bool) {
+	var results []FilterResult
+	for _, filter := range relayFilters {
+		result := filter(m)
+		if result.Action != FilterPass || result.Notice != "" {
+			results = append(results, result)
+		}
+		if result.Action == FilterBlock || result.Action == FilterHold {
+			return results, false
+		}
+	}
+	return results, true
+}
+
+// messageKind names the content of a message the way the relay filters and
+// BLOCKED_CONTENT_TYPES refer to it.
+func messageKind(msg *Message) string {
+	switch {
+	case msg.Text != "":
+		return "Text"
+	case len(msg.Photo) > 0:
+		return "Photo"
+	case msg.Video != nil:
+		return "Video"
+	case msg.Animation != nil:
+		return "Animation"
+	case msg.Sticker != nil:
+		return "Sticker"
+	case msg.Voice != nil:
+		return "Voice"
+	case msg.VideoNote != nil:
+		return "VideoNote"
+	case msg.Audio != nil:
+		return "Audio"
+	case msg.Document != nil:
+		return "Document"
+	case msg.Contact != nil:
+		return "Contact"
+	case msg.Venue != nil:
+		return "Venue"
+	case msg.Location != nil:
+		return "Location"
+	}
+	return "Other"
+}
+
+// linkPattern only matches text that is clearly a link. A bare domain only
+// counts when Telegram made it clickable, which it reports as a url entity.
+var linkPattern = regexp.MustCompile(`(?i)\b(https?://|www\.)\S+`)
+
+// hasLink reports whether the text contains a link, including links hidden
+// behind formatted text.
+func hasLink(text string, entities []MessageEntity) bool {
+	for _, entity := range entities {
+		if entity.Type == "url" || entity.Type == "text_link" {
+			return true
+		}
+	}
+	return linkPattern.MatchString(text)
+}
+
+func linkFilter(m *OutgoingMessage) FilterResult {
+	if hasLink(m.Text, m.Entities) {
+		return FilterResult{Action: FilterBlock, Reason: "link", Notice: MessageLinkBlocked}
+	}
+	return FilterResult{}
+}
+
+func maxLengthFilter(cfg Config) RelayFilter {
+	return func(m *OutgoingMessage) FilterResult {
+		if cfg.MaxMessageLength > 0 && utf8.RuneCountInString(m.Text) > cfg.MaxMessageLength {
+			return FilterResult{
+				Action: FilterBlock,
+				Reason: "max length",
+				Notice: fmt.Sprintf(MessageTooLong, cfg.MaxMessageLength),
+			}
+		}
+		return FilterResult{}
+	}
+}
+
+func contentTypeFilter(cfg Config) RelayFilter {
+	return func(m *OutgoingMessage) FilterResult {
+		blocked := slices.ContainsFunc(cfg.BlockedContentTypes, func(kind string) bool {
+			return strings.EqualFold(kind, m.Kind)
+		})
+		if blocked {
+			return FilterResult{Action: FilterBlock, Reason: "content type " + m.Kind, Notice: MessageContentTypeBlocked}
+		}
+		return FilterResult{}
+	}
+}
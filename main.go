@@ -2,13 +2,16 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -20,10 +23,13 @@ import (
 )
 
 var (
-	bot       *tgx.Bot
-	userStore *store.DynamoDBStore
-	userCache = make(map[int64]*store.User)
-	cacheLock = &sync.RWMutex{}
+	bot          *tgx.Bot
+	botToken     string
+	config       Config
+	relayFilters []RelayFilter
+	userStore    *store.DynamoDBStore
+	userCache    = make(map[int64]*store.User)
+	cacheLock    = &sync.RWMutex{}
 )
 
 func getUserFromCache(chatId int64) (*store.User, bool) {
@@ -74,38 +80,55 @@ func UpdateUser(ctx context.Context, user *store.User) error {
 	return nil
 }
 
-func init() {
-	token := os.Getenv("BOT_TOKEN")
-	tableName := os.Getenv("DYNAMODB_TABLE")
-	if token == "" || tableName == "" {
-		log.Fatal("FATAL: BOT_TOKEN and DYNAMODB_TABLE environment variables must be set")
+// setup reads the configuration, connects to DynamoDB and registers the
+// handlers. It runs from main rather than init so tests can use the package
+// without a deployment.
+func setup() {
+	botToken = os.Getenv("BOT_TOKEN")
+	tables := store.Tables{
+		Users:        os.Getenv("DYNAMODB_TABLE"),
+		Messages:     os.Getenv("MESSAGES_TABLE"),
+		MediaGroups:  os.Getenv("MEDIA_GROUPS_TABLE"),
+		RateLimits:   os.Getenv("RATE_LIMITS_TABLE"),
+		Reports:      os.Getenv("REPORTS_TABLE"),
+		Ratings:      os.Getenv("RATINGS_TABLE"),
+		Fingerprints: os.Getenv("FINGERPRINTS_TABLE"),
+		Metrics:      os.Getenv("METRICS_TABLE"),
+		MediaConsent: os.Getenv("MEDIA_CONSENT_TABLE"),
+	}
+	if botToken == "" || tables.Users == "" || tables.Messages == "" || tables.MediaGroups == "" || tables.RateLimits == "" || tables.Reports == "" || tables.Ratings == "" || tables.Fingerprints == "" || tables.Metrics == "" || tables.MediaConsent == "" {
+		log.Fatal("FATAL: BOT_TOKEN, DYNAMODB_TABLE, MESSAGES_TABLE, MEDIA_GROUPS_TABLE, RATE_LIMITS_TABLE, REPORTS_TABLE, RATINGS_TABLE, FINGERPRINTS_TABLE, METRICS_TABLE and MEDIA_CONSENT_TABLE environment variables must be set")
+	}
+
+	config = loadConfig()
+	relayFilters = buildRelayFilters(config)
+	if config.CrisisDetection {
+		crisisLists = loadCrisisLists(config.CrisisListDir, config.CrisisLanguages)
+		log.Printf("LOG: Loaded crisis phrases for %d languages.", len(crisisLists))
 	}
 
 	logger := logger.NewDefaultLogger(logger.INFO)
 
 	var err error
-	userStore, err = store.New(context.Background(), tableName)
+	userStore, err = store.New(context.Background(), tables)
 	if err != nil {
 		log.Fatalf("FATAL: failed to initialize DynamoDB store: %v", err)
 	}
 
-	bot = tgx.NewBot(token, "", logger)
+	// tgx uses the default transport, so this also covers its sends.
+	http.DefaultTransport = &retryTransport{base: http.DefaultTransport}
+	bot = tgx.NewBot(botToken, "", logger)
 
 	bot.OnError(func(ctx *tgx.Context, err error) {
 		log.Printf("ERROR: An error occurred in an update: %v", err)
-		ctx.Reply(MessageErrSomethingWentWrong)
+		ctx.Reply(sendFailureMessage(err))
 	})
 
 	bot.SetMyCommands(Commands)
 
 	bot.OnCommand("start", func(ctx *tgx.Context) error {
 		log.Println("LOG: Handling /start command")
-		req := &tgx.SendMessageRequest{
-			ChatId:      ctx.ChatID,
-			Text:        "👋 Welcome! Chat anonymously with random people here. Type /connect to start or /help for commands!",
-			ReplyMarkup: models.InlineKeyboardMarkup{InlineKeyboard: inlineKeyboardButton},
-		}
-		return bot.SendMessageWithOpts(req)
+		return HandleStart(ctx)
 	})
 
 	bot.OnCommand("help", func(ctx *tgx.Context) error {
@@ -140,6 +163,40 @@ func init() {
 		return HandlePartnerGender(ctx)
 	})
 
+	bot.OnCommand("autowipe", func(ctx *tgx.Context) error {
+		return HandleAutoWipe(ctx)
+	})
+
+	bot.OnCommand("protect", func(ctx *tgx.Context) error {
+		return HandleProtect(ctx)
+	})
+
+	bot.OnCommand("viewonce", func(ctx *tgx.Context) error {
+		return HandleViewOnce(ctx)
+	})
+
+	bot.OnCommand("reveal", func(ctx *tgx.Context) error {
+		return HandleReveal(ctx)
+	})
+
+	bot.OnCommand("mylevel", func(ctx *tgx.Context) error {
+		return HandleMyLevel(ctx)
+	})
+
+	bot.OnCommand("media", func(ctx *tgx.Context) error {
+		return HandleMediaSetting(ctx)
+	})
+
+	bot.OnCommand("appeal", func(ctx *tgx.Context) error {
+		return HandleAppeal(ctx)
+	})
+
+	bot.OnCommand("ban", adminOnly(HandleAdminBan))
+	bot.OnCommand("unban", adminOnly(HandleAdminUnban))
+	bot.OnCommand("user", adminOnly(HandleAdminUser))
+	bot.OnCommand("reports", adminOnly(HandleAdminReports))
+	bot.OnCommand("stats", adminOnly(HandleAdminStats))
+
 	bot.OnCallback("connect", func(ctx *tgx.CallbackContext) error {
 		err := HandleConnect(bot, ctx.GetChatID())
 		if err != nil {
@@ -156,141 +213,82 @@ func init() {
 		return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{})
 	})
 
-	bot.OnCallback(CallbackGenderPrefix, func(ctx *tgx.CallbackContext) error {
-		gender := strings.TrimPrefix(ctx.Data, CallbackGenderPrefix)
-		chatId := ctx.GetChatID()
-
-		user, err := GetUser(context.Background(), chatId)
-		if err != nil {
-			user = &store.User{ChatId: chatId}
-		}
-
-		user.Gender = gender
-		if err := UpdateUser(context.Background(), user); err != nil {
-			log.Printf("ERROR: Failed to update user %d gender from callback: %v", chatId, err)
-			return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{Text: MessageErrSomethingWentWrong, ShowAlert: true})
-		}
-
-		// Edit the message to remove the keyboard and show confirmation
-		editedText := fmt.Sprintf(MessageGenderSet, gender)
-		err = ctx.EditMessage(editedText, &tgx.EditMessageOptions{ReplyMarkup: nil})
-		if err != nil {
-			log.Printf("ERROR: Failed to edit message text for user %d: %v", chatId, err)
-		}
-
-		return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{Text: editedText, ShowAlert: false}) // Show as toast
+	bot.OnCallback(CallbackRulesAgree, func(ctx *tgx.CallbackContext) error {
+		return HandleRulesAnswer(ctx, false)
 	})
 
-	bot.OnCallback(CallbackPartnerGenderPrefix, func(ctx *tgx.CallbackContext) error {
-		gender := strings.TrimPrefix(ctx.Data, CallbackPartnerGenderPrefix)
-		chatId := ctx.GetChatID()
+	bot.OnCallback(CallbackRulesMinor, func(ctx *tgx.CallbackContext) error {
+		return HandleRulesAnswer(ctx, true)
+	})
 
-		user, err := GetUser(context.Background(), chatId)
-		if err != nil {
-			user = &store.User{ChatId: chatId}
-		}
+	for _, gender := range genders {
+		bot.OnCallback(CallbackGenderPrefix+gender, func(ctx *tgx.CallbackContext) error {
+			return HandleGenderChoice(ctx, gender, false)
+		})
+	}
 
-		user.PartnerGender = gender
-		if err := UpdateUser(context.Background(), user); err != nil {
-			log.Printf("ERROR: Failed to update user %d partner gender from callback: %v", chatId, err)
-			return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{Text: MessageErrSomethingWentWrong, ShowAlert: true})
-		}
+	for _, gender := range partnerGenders {
+		bot.OnCallback(CallbackPartnerGenderPrefix+gender, func(ctx *tgx.CallbackContext) error {
+			return HandleGenderChoice(ctx, gender, true)
+		})
+	}
 
-		// Edit the message to remove the keyboard and show confirmation
-		editedText := fmt.Sprintf(MessagePartnerGenderSet, gender)
-		err = ctx.EditMessage(editedText, &tgx.EditMessageOptions{ReplyMarkup: nil})
-		if err != nil {
-			log.Printf("ERROR: Failed to edit message text for user %d: %v", chatId, err)
-		}
+	for _, reason := range reportReasons {
+		bot.OnCallback(CallbackReportPrefix+reason, func(ctx *tgx.CallbackContext) error {
+			return HandleReportReason(ctx, reason)
+		})
+	}
 
-		return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{Text: editedText, ShowAlert: false}) // Show as toast
+	bot.OnCallback(CallbackReportCancel, func(ctx *tgx.CallbackContext) error {
+		ctx.EditMessage(MessageReportCancelled, nil)
+		return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{})
 	})
 
-	bot.OnMessage("Text", func(ctx *tgx.Context) error {
-		partnerChatId, errMsg := CheckAndGetPartner(ctx.ChatID)
-		if errMsg != "" {
-			return ctx.Reply(errMsg)
-		}
-		return bot.SendMessage(partnerChatId, ctx.Text)
+	bot.OnCallback(CallbackMediaAllow, func(ctx *tgx.CallbackContext) error {
+		return HandleMediaConsent(ctx, true)
 	})
 
-	bot.OnMessage("Animation", func(ctx *tgx.Context) error {
-		partnerChatId, errMsg := CheckAndGetPartner(ctx.ChatID)
-		if errMsg != "" {
-			return ctx.Reply(errMsg)
-		}
-		req := &tgx.SendAnimationRequest{
-			Animation:        ctx.Animation.FileId,
-			BaseMediaRequest: tgx.BaseMediaRequest{ChatId: partnerChatId},
-		}
-		return bot.SendAnimation(req)
+	bot.OnCallback(CallbackMediaDecline, func(ctx *tgx.CallbackContext) error {
+		return HandleMediaConsent(ctx, false)
 	})
 
-	bot.OnMessage("Photo", func(ctx *tgx.Context) error {
-		partnerChatId, errMsg := CheckAndGetPartner(ctx.ChatID)
-		if errMsg != "" {
-			return ctx.Reply(errMsg)
-		}
-		req := &tgx.SendPhotoRequest{
-			Photo:            ctx.Photo[0].FileId,
-			BaseMediaRequest: tgx.BaseMediaRequest{ChatId: partnerChatId},
-		}
-		return bot.SendPhoto(req)
+	bot.OnCallback(CallbackRevealAccept, func(ctx *tgx.CallbackContext) error {
+		return HandleRevealAnswer(ctx, true)
 	})
 
-	bot.OnMessage("Voice", func(ctx *tgx.Context) error {
-		partnerChatId, errMsg := CheckAndGetPartner(ctx.ChatID)
-		if errMsg != "" {
-			return ctx.Reply(errMsg)
-		}
-		req := &tgx.SendVoiceRequest{
-			Voice:            ctx.Voice.FileId,
-			BaseMediaRequest: tgx.BaseMediaRequest{ChatId: partnerChatId},
-		}
-		return bot.SendVoice(req)
+	bot.OnCallback(CallbackRevealDecline, func(ctx *tgx.CallbackContext) error {
+		return HandleRevealAnswer(ctx, false)
 	})
 
-	bot.OnMessage("Document", func(ctx *tgx.Context) error {
-		partnerChatId, errMsg := CheckAndGetPartner(ctx.ChatID)
-		if errMsg != "" {
-			return ctx.Reply(errMsg)
-		}
-		req := &tgx.SendDocumentRequest{
-			Document:         ctx.Document.FileId,
-			BaseMediaRequest: tgx.BaseMediaRequest{ChatId: partnerChatId},
-		}
-		return bot.SendDocument(req)
+	bot.OnCallback(CallbackHeldSend, func(ctx *tgx.CallbackContext) error {
+		return HandleHeldMessage(ctx, true)
 	})
 
-	bot.OnMessage("Video", func(ctx *tgx.Context) error {
-		partnerChatId, errMsg := CheckAndGetPartner(ctx.ChatID)
-		if errMsg != "" {
-			return ctx.Reply(errMsg)
-		}
-		req := &tgx.SendVideoRequest{
-			Video:            ctx.Video.FileId,
-			BaseMediaRequest: tgx.BaseMediaRequest{ChatId: partnerChatId},
-		}
-		return bot.SendVideo(req)
+	bot.OnCallback(CallbackHeldCancel, func(ctx *tgx.CallbackContext) error {
+		return HandleHeldMessage(ctx, false)
 	})
 
-	bot.OnMessage("Sticker", func(ctx *tgx.Context) error {
-		partnerChatId, errMsg := CheckAndGetPartner(ctx.ChatID)
-		if errMsg != "" {
-			return ctx.Reply(errMsg)
-		}
-		req := &tgx.SendStickerRequest{
-			Sticker:          ctx.Sticker.FileId,
-			BaseMediaRequest: tgx.BaseMediaRequest{ChatId: partnerChatId},
-		}
-		return bot.SendSticker(req)
-	})
+	onRawCommand("unsend", HandleUnsend)
+	onRawCallback(CallbackAdminApprove, HandleAdminDecision)
+	onRawCallback(CallbackAdminDismiss, HandleAdminDecision)
+	onRawCallback(CallbackAdminBan, HandleAdminDecision)
+	onRawCallback(CallbackAppealAccept, HandleAppealDecision)
+	onRawCallback(CallbackAppealReject, HandleAppealDecision)
+	onRawCallback(CallbackRateUp, HandleRating)
+	onRawCallback(CallbackRateDown, HandleRating)
+	onRawCallback(CallbackChallengePrefix, HandleChallengeAnswer)
 
 	log.Println("--- BOT INITIALIZED SUCCESSFULLY ---")
 }
 
 func HandleRequest(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
 	log.Printf("Handler invoked! Request Body: %s", req.Body)
+	if deadline, ok := ctx.Deadline(); ok {
+		setSendDeadline(deadline)
+	}
+	if dispatchUpdate(bot, []byte(req.Body)) {
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusOK}, nil
+	}
 	httpRequest, err := http.NewRequest("POST", "/", strings.NewReader(req.Body))
 	if err != nil {
 		log.Printf("ERROR: Could not create new HTTP request: %v", err)
@@ -303,13 +301,14 @@ func HandleRequest(ctx context.Context, req events.APIGatewayV2HTTPRequest) (eve
 }
 
 func main() {
+	setup()
 	lambda.Start(HandleRequest)
 }
 
 func HandleConnect(b *tgx.Bot, chatId int64) error {
 	log.Printf("LOG: HandleConnect called for ChatID: %d", chatId)
 	ctx := context.Background()
-	const REPORT_THRESHOLD = 3
+	const REPORT_THRESHOLD = 3.0
 
 	user, err := GetUser(ctx, chatId)
 	if err != nil {
@@ -322,7 +321,36 @@ func HandleConnect(b *tgx.Bot, chatId int64) error {
 		return b.SendMessage(chatId, MessageAlreadyConnected)
 	}
 
+	if user.Inactive {
+		log.Printf("LOG: User %d is back, marking them active.", chatId)
+		user.Inactive = false
+	}
+
+	if user.IsBanned(time.Now()) {
+		log.Printf("LOG: Banned user %d tried to connect.", chatId)
+		return b.SendMessage(chatId, banMessage(user))
+	}
+
+	if step := onboardingStep(user); step != onboardingDone {
+		log.Printf("LOG: User %d has to finish onboarding (%s) before connecting.", chatId, step)
+		return sendOnboardingStep(b, user)
+	}
+
+	if needsChallenge(user) {
+		if challengeBlocked(user, time.Now()) {
+			return b.SendMessage(chatId, challengeBlockMessage(user))
+		}
+		log.Printf("LOG: Sending a challenge to new user %d.", chatId)
+		if err := sendChallenge(b, user); err != nil {
+			return err
+		}
+		return UpdateUser(ctx, user)
+	}
+
 	updatedUser, partner, err := userStore.FindAndConnectPartner(ctx, user)
+	if errors.Is(err, store.ErrBanned) {
+		return b.SendMessage(chatId, banMessage(user))
+	}
 	if err != nil {
 		log.Printf("ERROR: FindAndConnectPartner failed for %d: %v", chatId, err)
 		return b.SendMessage(chatId, MessageErrSomethingWentWrong)
@@ -333,15 +361,18 @@ func HandleConnect(b *tgx.Bot, chatId int64) error {
 		// Update cache with the fresh objects returned from the transaction
 		setUserInCache(updatedUser)
 		setUserInCache(partner)
+		if err := userStore.IncrementMetric(ctx, "chats", "started"); err != nil {
+			log.Printf("WARN: Failed to count chat: %v", err)
+		}
 
 		b.SendMessage(user.ChatId, MessageConnected)
 		b.SendMessage(partner.ChatId, MessageConnected)
 
 		// Check report counts and send warnings if necessary
-		if partner.ReportCount >= REPORT_THRESHOLD {
+		if reportScore(partner) >= REPORT_THRESHOLD {
 			b.SendMessage(user.ChatId, MessagePartnerReportWarning)
 		}
-		if updatedUser.ReportCount >= REPORT_THRESHOLD {
+		if reportScore(updatedUser) >= REPORT_THRESHOLD {
 			b.SendMessage(partner.ChatId, MessagePartnerReportWarning)
 		}
 
@@ -349,7 +380,7 @@ func HandleConnect(b *tgx.Bot, chatId int64) error {
 	}
 
 	log.Printf("LOG: No partner found for %d. Attempting to put user in queue.", chatId)
-	user.IsConnecting = 1
+	user.IsConnecting = user.Queue(time.Now())
 	if err := UpdateUser(ctx, user); err != nil {
 		log.Printf("ERROR: Failed to put user %d into queue: %v", chatId, err)
 		return b.SendMessage(chatId, MessageErrSomethingWentWrong)
@@ -369,29 +400,136 @@ func HandleStop(b *tgx.Bot, chatId int64) error {
 		return b.SendMessage(chatId, MessageConnectWithSomeoneFirst)
 	}
 
+	sessionId, ratedPartner := user.SessionId, int64(0)
+	var partner *store.User
 	if user.IsConnected {
 		log.Printf("LOG: User %d is disconnecting from partner %d.", chatId, user.Partner)
-		partner, err := GetUser(ctx, user.Partner)
-		if err == nil {
-			partner.IsConnected = false
-			partner.Partner = 0
-			UpdateUser(ctx, partner)
-			b.SendMessage(partner.ChatId, MessagePartnerLeftChat)
-		} else {
-			log.Printf("WARN: Could not find partner %d to notify about disconnection for user %d.", user.Partner, chatId)
+		partner = getPartner(ctx, user)
+	}
+	endSession(ctx, user, partner)
+
+	if partner != nil {
+		if err := UpdateUser(ctx, partner); err != nil {
+			log.Printf("ERROR: Failed to disconnect partner %d of user %d: %v", partner.ChatId, chatId, err)
 		}
+		b.SendMessage(partner.ChatId, MessagePartnerLeftChat)
+		ratedPartner = partner.ChatId
 	}
 
 	log.Printf("LOG: Resetting status for user %d.", chatId)
-	user.IsConnected = false
-	user.IsConnecting = 0
-	user.Partner = 0
 	if err := UpdateUser(ctx, user); err != nil {
 		log.Printf("ERROR: Failed to update user %d on stop: %v", chatId, err)
 		return b.SendMessage(chatId, MessageErrSomethingWentWrong)
 	}
 
-	return b.SendMessage(chatId, MessageChatEnded)
+	if err := b.SendMessage(chatId, MessageChatEnded); err != nil {
+		return err
+	}
+	if ratedPartner != 0 && sessionId != "" {
+		askForRatings(b, sessionId, chatId, ratedPartner)
+	}
+	return nil
+}
+
+// HandlePartnerGone ends the session of a user whose partner blocked the bot
+// or deleted their account, and keeps the partner out of matching.
+func HandlePartnerGone(b *tgx.Bot, user *store.User) error {
+	ctx := context.Background()
+
+	partner := getPartner(ctx, user)
+	endSession(ctx, user, partner)
+
+	if partner != nil {
+		log.Printf("LOG: Marking user %d inactive.", partner.ChatId)
+		partner.Inactive = true
+		if err := UpdateUser(ctx, partner); err != nil {
+			log.Printf("ERROR: Failed to mark user %d inactive: %v", partner.ChatId, err)
+		}
+	}
+
+	if err := UpdateUser(ctx, user); err != nil {
+		log.Printf("ERROR: Failed to update user %d after partner left: %v", user.ChatId, err)
+		return b.SendMessage(user.ChatId, MessageErrSomethingWentWrong)
+	}
+
+	return b.SendMessage(user.ChatId, MessagePartnerLeftChat)
+}
+
+// HandleMyChatMember takes a user who blocked the bot out of the queue and
+// their chat, and keeps them out of matching until they /start again.
+func HandleMyChatMember(b *tgx.Bot, update *ChatMemberUpdated) error {
+	chatId := update.Chat.Id
+	if update.Chat.Type != "private" || update.NewChatMember.Status != "kicked" {
+		return nil
+	}
+	log.Printf("LOG: User %d blocked the bot.", chatId)
+	ctx := context.Background()
+
+	user, err := GetUser(ctx, chatId)
+	if err != nil {
+		log.Printf("LOG: User %d who blocked the bot is not in DB, nothing to do.", chatId)
+		return nil
+	}
+
+	var partner *store.User
+	if user.IsConnected {
+		partner = getPartner(ctx, user)
+	}
+	endSession(ctx, user, partner)
+
+	if partner != nil {
+		if err := UpdateUser(ctx, partner); err != nil {
+			log.Printf("ERROR: Failed to disconnect partner %d of user %d: %v", partner.ChatId, chatId, err)
+		}
+		b.SendMessage(partner.ChatId, MessagePartnerLeftChat)
+	}
+
+	user.Inactive = true
+	return UpdateUser(ctx, user)
+}
+
+// getPartner loads the user's partner, or returns nil if they can't be found.
+func getPartner(ctx context.Context, user *store.User) *store.User {
+	partner, err := GetUser(ctx, user.Partner)
+	if err != nil {
+		log.Printf("WARN: Could not find partner %d of user %d: %v", user.Partner, user.ChatId, err)
+		return nil
+	}
+	return partner
+}
+
+// endSession takes the user and their partner, if any, out of their chat and
+// the queue. The session is wiped when either of them asked for it, and
+// otherwise view-once media that was not seen yet is removed. The caller
+// saves both users.
+func endSession(ctx context.Context, user, partner *store.User) {
+	users := []*store.User{user}
+	if partner != nil {
+		users = append(users, partner)
+	}
+
+	wipe := false
+	for _, u := range users {
+		wipe = wipe || u.WipeOnEnd
+	}
+	if wipe && user.SessionId != "" {
+		wipeSession(user.SessionId)
+	} else {
+		for _, u := range users {
+			if u.ViewOncePending {
+				deleteViewOnce(ctx, u.SessionId, u.ChatId)
+			}
+		}
+	}
+
+	for _, u := range users {
+		u.IsConnected = false
+		u.IsConnecting = 0
+		u.Partner = 0
+		u.SessionId = ""
+		u.ViewOncePending = false
+		u.HeldMessage = ""
+	}
 }
 
 func HandleNext(b *tgx.Bot, chatId int64) error {
@@ -409,27 +547,29 @@ func HandleStatus(b *tgx.Bot, chatId int64) error {
 	if user.IsConnected {
 		return b.SendMessage(chatId, MessageCurrentlyChatting)
 	}
-	if user.IsConnecting == 1 {
+	if user.IsConnecting != 0 {
 		return b.SendMessage(chatId, MessageInWaitingList)
 	}
 	return b.SendMessage(chatId, MessageNotConnectedStatus)
 }
 
-func CheckAndGetPartner(chatId int64) (int64, string) {
+// getConnectedUser returns the user if they are in a chat, or the message to
+// send them otherwise.
+func getConnectedUser(ctx context.Context, chatId int64) (*store.User, string) {
 	log.Printf("LOG: Checking for partner for ChatID %d", chatId)
 
-	user, err := GetUser(context.Background(), chatId)
+	user, err := GetUser(ctx, chatId)
 	if err != nil {
 		log.Printf("WARN: User %d not found in DB for partner check.", chatId)
-		return 0, MessageNotConnected
+		return nil, MessageNotConnected
 	}
 	if !user.IsConnected || user.Partner == 0 {
 		log.Printf("LOG: User %d is not currently connected to a partner.", chatId)
-		return 0, MessageNotConnected
+		return nil, MessageNotConnected
 	}
 
 	log.Printf("LOG: Found partner %d for user %d.", user.Partner, chatId)
-	return user.Partner, ""
+	return user, ""
 }
 
 func HandleReport(b *tgx.Bot, chatId int64) error {
@@ -442,24 +582,11 @@ func HandleReport(b *tgx.Bot, chatId int64) error {
 		return b.SendMessage(chatId, MessageNotInChat)
 	}
 
-	partner, err := GetUser(ctx, user.Partner)
-	if err != nil {
-		log.Printf("ERROR: Could not find partner %d to report for user %d.", user.Partner, chatId)
-		return b.SendMessage(chatId, MessageErrSomethingWentWrong)
-	}
-
-	partner.ReportCount++
-	if err := UpdateUser(ctx, partner); err != nil {
-		log.Printf("ERROR: Failed to update partner %d report count: %v", partner.ChatId, err)
-		return b.SendMessage(chatId, MessageErrSomethingWentWrong)
-	}
-
-	log.Printf("LOG: User %d reported partner %d. New report count: %d", chatId, partner.ChatId, partner.ReportCount)
-
-	// Disconnect the users
-	HandleStop(b, chatId)
-
-	return b.SendMessage(chatId, MessageReportConfirmation)
+	return b.SendMessageWithOpts(&tgx.SendMessageRequest{
+		ChatId:      chatId,
+		Text:        MessageReportReason,
+		ReplyMarkup: models.InlineKeyboardMarkup{InlineKeyboard: inlineKeyboardReportReasons},
+	})
 }
 
 func HandleMyGender(ctx *tgx.Context) error {
@@ -468,13 +595,13 @@ func HandleMyGender(ctx *tgx.Context) error {
 		// Send inline keyboard for gender selection
 		req := &tgx.SendMessageRequest{
 			ChatId:      ctx.ChatID,
-			Text:        "Please select your gender:",
+			Text:        MessageAskGender,
 			ReplyMarkup: models.InlineKeyboardMarkup{InlineKeyboard: inlineKeyboardGender},
 		}
 		return bot.SendMessageWithOpts(req)
 	}
 	gender := strings.ToLower(args[0])
-	if gender != "male" && gender != "female" && gender != "other" {
+	if !slices.Contains(genders, gender) {
 		return ctx.Reply(MessageInvalidGender)
 	}
 
@@ -497,13 +624,13 @@ func HandlePartnerGender(ctx *tgx.Context) error {
 		// Send inline keyboard for partner gender selection
 		req := &tgx.SendMessageRequest{
 			ChatId:      ctx.ChatID,
-			Text:        "Please select your preferred partner gender:",
+			Text:        MessageAskPartnerGender,
 			ReplyMarkup: models.InlineKeyboardMarkup{InlineKeyboard: inlineKeyboardPartnerGender},
 		}
 		return bot.SendMessageWithOpts(req)
 	}
 	gender := strings.ToLower(args[0])
-	if gender != "male" && gender != "female" && gender != "any" {
+	if !slices.Contains(partnerGenders, gender) {
 		return ctx.Reply(MessageInvalidPartnerGender)
 	}
 
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/harshyadavone/anonymous_chat/store"
+	"github.com/harshyadavone/tgx"
+	"github.com/harshyadavone/tgx/models"
+)
+
+// Whether a user receives media from their partners, set with /media.
+const (
+	MediaPolicyAsk   = "ask"
+	MediaPolicyAllow = "allow"
+	MediaPolicyBlock = "block"
+)
+
+// What a user decided about receiving media in their current session.
+const (
+	MediaConsentPending  = "pending"
+	MediaConsentAllowed  = "allowed"
+	MediaConsentDeclined = "declined"
+)
+
+// How many media messages are held for one consent prompt, enough for an
+// album.
+const maxHeldMedia = 10
+
+// mediaNames describes the kinds of messages that need consent.
+var mediaNames = map[string]string{
+	"Photo":     "a photo",
+	"Video":     "a video",
+	"Animation": "a GIF",
+	"VideoNote": "a video message",
+	"Document":  "a file",
+	"Sticker":   "a sticker",
+	"Voice":     "a voice message",
+}
+
+// checkMediaConsent holds the media a user sends in a session until their
+// partner allows media, unless the partner always allows or always blocks
+// it. Only the first held message prompts the partner. It reports whether
+// the message may be relayed now.
+func checkMediaConsent(ctx context.Context, b *tgx.Bot, m *OutgoingMessage) bool {
+	user, msg := m.Sender, m.Msg
+	name, ok := mediaNames[m.Kind]
+	if !ok {
+		return true
+	}
+	partner, err := GetUser(ctx, user.Partner)
+	if err != nil {
+		// Let the relay find out what happened to the partner.
+		return true
+	}
+
+	switch partner.MediaPolicy {
+	case MediaPolicyAllow:
+		return true
+	case MediaPolicyBlock:
+		b.SendMessage(user.ChatId, MessageMediaBlocked)
+		return false
+	}
+
+	asked, state, err := userStore.RequestMediaConsent(ctx, user.SessionId, partner.ChatId, MediaConsentPending)
+	if err != nil {
+		log.Printf("ERROR: Failed to check media consent of user %d: %v", partner.ChatId, err)
+		b.SendMessage(user.ChatId, MessageErrSomethingWentWrong)
+		return false
+	}
+	switch state {
+	case MediaConsentAllowed:
+		return true
+	case MediaConsentDeclined:
+		b.SendMessage(user.ChatId, MessageMediaDeclined)
+		return false
+	}
+
+	encoded, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("ERROR: Failed to encode media %d of user %d: %v", msg.MessageId, user.ChatId, err)
+		return false
+	}
+	held, err := userStore.HoldMedia(ctx, &store.HeldMedia{
+		SessionId: user.SessionId,
+		ChatId:    user.ChatId,
+		MessageId: msg.MessageId,
+		Message:   string(encoded),
+		Text:      m.Text,
+		Modified:  m.Modified,
+	}, maxHeldMedia)
+	if err != nil {
+		log.Printf("ERROR: Failed to hold media %d of user %d: %v", msg.MessageId, user.ChatId, err)
+		b.SendMessage(user.ChatId, MessageErrSomethingWentWrong)
+		return false
+	}
+	if !held {
+		log.Printf("LOG: Dropping media %d from %d, %d are already held for partner %d.", msg.MessageId, user.ChatId, maxHeldMedia, partner.ChatId)
+		b.SendMessage(user.ChatId, fmt.Sprintf(MessageMediaHeldFull, maxHeldMedia))
+		return false
+	}
+	log.Printf("LOG: Holding media %d from %d until partner %d allows it.", msg.MessageId, user.ChatId, partner.ChatId)
+
+	if !asked {
+		// The partner may have answered while this message was being held,
+		// in which case nobody else is left to deliver it.
+		state, err := userStore.GetMediaConsent(ctx, user.SessionId, partner.ChatId)
+		if err == nil && state != MediaConsentPending {
+			releaseHeldMedia(ctx, user, state == MediaConsentAllowed)
+		}
+		return false
+	}
+
+	err = b.SendMessageWithOpts(&tgx.SendMessageRequest{
+		ChatId:      partner.ChatId,
+		Text:        fmt.Sprintf(MessageMediaConsent, name),
+		ReplyMarkup: models.InlineKeyboardMarkup{InlineKeyboard: inlineKeyboardMediaConsent},
+	})
+	if err != nil {
+		log.Printf("ERROR: Failed to send media consent prompt to %d: %v", partner.ChatId, err)
+	}
+	b.SendMessage(user.ChatId, MessageMediaWaiting)
+	return false
+}
+
+// releaseHeldMedia takes the media the sender has waiting in their session
+// and delivers it to the partner, or drops it. Held media is sent the way
+// the relay would have sent it, with the filtered caption, view-once spoiler
+// and albums kept together.
+func releaseHeldMedia(ctx context.Context, sender *store.User, deliver bool) error {
+	held, err := userStore.TakeHeldMedia(ctx, sender.SessionId, sender.ChatId)
+	if err != nil {
+		log.Printf("ERROR: Failed to take held media of user %d: %v", sender.ChatId, err)
+	}
+	if !deliver || len(held) == 0 {
+		return nil
+	}
+
+	messages := make([]*OutgoingMessage, 0, len(held))
+	for _, h := range held {
+		var msg Message
+		if err := json.Unmarshal([]byte(h.Message), &msg); err != nil {
+			log.Printf("ERROR: Failed to decode held media %d of user %d: %v", h.MessageId, sender.ChatId, err)
+			continue
+		}
+		m := newOutgoingMessage(sender, &msg)
+		m.Text, m.Modified = h.Text, h.Modified
+		messages = append(messages, m)
+	}
+
+	for i := 0; i < len(messages); {
+		// Parts of an album that were held together go out together.
+		j := i + 1
+		groupId := messages[i].Msg.MediaGroupId
+		for groupId != "" && j < len(messages) && messages[j].Msg.MediaGroupId == groupId {
+			j++
+		}
+
+		var err error
+		parts := heldAlbumParts(messages[i:j])
+		if len(parts) > 1 {
+			err = sendAlbum(ctx, sender, parts)
+		} else {
+			for _, m := range messages[i:j] {
+				if err = relayCopy(ctx, m); err != nil {
+					break
+				}
+			}
+		}
+		if isChatGone(err) {
+			return err
+		}
+		if err != nil {
+			log.Printf("ERROR: Failed to send held media %d of user %d: %v", messages[i].Msg.MessageId, sender.ChatId, err)
+		}
+		i = j
+	}
+	return nil
+}
+
+// heldAlbumParts turns held album messages into album parts, or returns nil
+// if any of them can't be sent as one.
+func heldAlbumParts(messages []*OutgoingMessage) []store.MediaGroupPart {
+	parts := make([]store.MediaGroupPart, 0, len(messages))
+	for _, m := range messages {
+		part, ok := albumPart(m.Msg)
+		if !ok {
+			return nil
+		}
+		part.Caption = m.Text
+		parts = append(parts, *part)
+	}
+	return parts
+}
+
+// HandleMediaConsent records whether a user wants media from their partner
+// in this session, and delivers or drops the media held for it.
+func HandleMediaConsent(ctx *tgx.CallbackContext, allow bool) error {
+	chatId := ctx.GetChatID()
+	bg := context.Background()
+
+	state := MediaConsentDeclined
+	if allow {
+		state = MediaConsentAllowed
+	}
+
+	user, errMsg := getConnectedUser(bg, chatId)
+	answered := false
+	if errMsg == "" {
+		var err error
+		answered, err = userStore.AnswerMediaConsent(bg, user.SessionId, chatId, MediaConsentPending, state)
+		if err != nil {
+			log.Printf("ERROR: Failed to save media consent of user %d: %v", chatId, err)
+			return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{Text: MessageErrSomethingWentWrong, ShowAlert: true})
+		}
+	}
+	if !answered {
+		ctx.EditMessage(MessageMediaConsentExpired, nil)
+		return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{})
+	}
+	log.Printf("LOG: User %d %s media from their partner.", chatId, state)
+
+	if !allow {
+		ctx.EditMessage(MessageMediaConsentDeclined, nil)
+	} else {
+		ctx.EditMessage(MessageMediaConsentAllowed, nil)
+	}
+	ctx.AnswerCallback(&tgx.CallbackAnswerOptions{})
+
+	sender, err := GetUser(bg, user.Partner)
+	if err != nil {
+		log.Printf("WARN: Could not find partner %d of user %d for held media: %v", user.Partner, chatId, err)
+		return nil
+	}
+	if err := releaseHeldMedia(bg, sender, allow); isChatGone(err) {
+		return HandlePartnerGone(bot, user)
+	}
+	if allow {
+		return bot.SendMessage(sender.ChatId, MessageMediaAllowed)
+	}
+	return bot.SendMessage(sender.ChatId, MessageMediaDeclined)
+}
+
+// HandleMediaSetting sets whether the user is asked before receiving media,
+// always receives it or never does.
+func HandleMediaSetting(ctx *tgx.Context) error {
+	user, err := GetUser(context.Background(), ctx.ChatID)
+	if errors.Is(err, store.ErrUserNotFound) {
+		user = &store.User{ChatId: ctx.ChatID}
+	} else if err != nil {
+		log.Printf("ERROR: Failed to get user %d: %v", ctx.ChatID, err)
+		return ctx.Reply(MessageErrSomethingWentWrong)
+	}
+
+	current := user.MediaPolicy
+	if current == "" {
+		current = MediaPolicyAsk
+	}
+	if len(ctx.Args) == 0 {
+		return ctx.Reply(fmt.Sprintf(MessageMediaSettingUsage, current))
+	}
+
+	switch policy := strings.ToLower(ctx.Args[0]); policy {
+	case MediaPolicyAsk, MediaPolicyAllow, MediaPolicyBlock:
+		user.MediaPolicy = policy
+	default:
+		return ctx.Reply(fmt.Sprintf(MessageMediaSettingUsage, current))
+	}
+	if err := UpdateUser(context.Background(), user); err != nil {
+		return ctx.Reply(MessageErrSomethingWentWrong)
+	}
+	return ctx.Reply(fmt.Sprintf(MessageMediaSettingSet, user.MediaPolicy))
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/harshyadavone/anonymous_chat/store"
+	"github.com/harshyadavone/tgx"
+	"github.com/harshyadavone/tgx/models"
+)
+
+// Steps a user goes through before their first chat, in order.
+const (
+	onboardingRules         = "rules"
+	onboardingGender        = "gender"
+	onboardingPartnerGender = "partner_gender"
+	onboardingDone          = ""
+)
+
+var (
+	genders        = []string{"male", "female", "other"}
+	partnerGenders = []string{"male", "female", "any"}
+)
+
+// onboardingStep returns the next thing a user has to do before they can
+// connect, or onboardingDone. Users who accepted an older version of the
+// rules have to accept them again.
+func onboardingStep(user *store.User) string {
+	switch {
+	case user.RulesVersion < config.RulesVersion:
+		return onboardingRules
+	case user.Gender == "":
+		return onboardingGender
+	case user.PartnerGender == "":
+		return onboardingPartnerGender
+	}
+	return onboardingDone
+}
+
+// sendOnboardingStep asks the user for the next step of their onboarding, or
+// welcomes them once they are done.
+func sendOnboardingStep(b *tgx.Bot, user *store.User) error {
+	text, keyboard := MessageWelcome, inlineKeyboardButton
+	switch onboardingStep(user) {
+	case onboardingRules:
+		text, keyboard = MessageRules, inlineKeyboardRules
+		if user.RulesVersion > 0 {
+			text = MessageRulesUpdated + "\n\n" + MessageRules
+		}
+	case onboardingGender:
+		text, keyboard = MessageAskGender, inlineKeyboardGender
+	case onboardingPartnerGender:
+		text, keyboard = MessageAskPartnerGender, inlineKeyboardPartnerGender
+	}
+	return b.SendMessageWithOpts(&tgx.SendMessageRequest{
+		ChatId:      user.ChatId,
+		Text:        text,
+		ReplyMarkup: models.InlineKeyboardMarkup{InlineKeyboard: keyboard},
+	})
+}
+
+// HandleStart greets a user and picks up their onboarding where they left
+// it.
+func HandleStart(ctx *tgx.Context) error {
+	bg := context.Background()
+	user, err := GetUser(bg, ctx.ChatID)
+	if err != nil {
+		user = &store.User{ChatId: ctx.ChatID}
+	} else if user.Inactive {
+		log.Printf("LOG: User %d is back, marking them active.", ctx.ChatID)
+		user.Inactive = false
+		if err := UpdateUser(bg, user); err != nil {
+			log.Printf("ERROR: Failed to mark user %d active: %v", ctx.ChatID, err)
+		}
+	}
+	return sendOnboardingStep(bot, user)
+}
+
+// HandleRulesAnswer records that a user accepted the current rules, either
+// as an adult or as a minor. Users who said they are under 18 once stay in
+// the minor pool.
+func HandleRulesAnswer(ctx *tgx.CallbackContext, minor bool) error {
+	chatId := ctx.GetChatID()
+	bg := context.Background()
+
+	user, err := GetUser(bg, chatId)
+	if errors.Is(err, store.ErrUserNotFound) {
+		user = &store.User{ChatId: chatId}
+	} else if err != nil {
+		log.Printf("ERROR: Failed to get user %d: %v", chatId, err)
+		return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{Text: MessageErrSomethingWentWrong, ShowAlert: true})
+	}
+
+	user.RulesVersion = config.RulesVersion
+	user.Minor = user.Minor || minor
+	if err := UpdateUser(bg, user); err != nil {
+		log.Printf("ERROR: Failed to save rules acceptance of user %d: %v", chatId, err)
+		return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{Text: MessageErrSomethingWentWrong, ShowAlert: true})
+	}
+	log.Printf("LOG: User %d accepted rules version %d, minor: %t.", chatId, user.RulesVersion, user.Minor)
+
+	text := MessageRulesAccepted
+	if user.Minor {
+		text = MessageRulesMinor
+	}
+	if err := ctx.EditMessage(text, &tgx.EditMessageOptions{ReplyMarkup: nil}); err != nil {
+		log.Printf("ERROR: Failed to edit message text for user %d: %v", chatId, err)
+	}
+	if err := sendOnboardingStep(bot, user); err != nil {
+		log.Printf("ERROR: Failed to send next onboarding step to user %d: %v", chatId, err)
+	}
+	return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{})
+}
+
+// HandleGenderChoice sets the user's gender, or the gender they want to be
+// matched with, from the selection buttons.
+func HandleGenderChoice(ctx *tgx.CallbackContext, gender string, partner bool) error {
+	chatId := ctx.GetChatID()
+	bg := context.Background()
+
+	user, err := GetUser(bg, chatId)
+	if errors.Is(err, store.ErrUserNotFound) {
+		user = &store.User{ChatId: chatId}
+	} else if err != nil {
+		log.Printf("ERROR: Failed to get user %d: %v", chatId, err)
+		return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{Text: MessageErrSomethingWentWrong, ShowAlert: true})
+	}
+	onboarding := onboardingStep(user) != onboardingDone
+
+	editedText := fmt.Sprintf(MessageGenderSet, gender)
+	if partner {
+		user.PartnerGender = gender
+		editedText = fmt.Sprintf(MessagePartnerGenderSet, gender)
+	} else {
+		user.Gender = gender
+	}
+	if err := UpdateUser(bg, user); err != nil {
+		log.Printf("ERROR: Failed to update user %d gender from callback: %v", chatId, err)
+		return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{Text: MessageErrSomethingWentWrong, ShowAlert: true})
+	}
+
+	// Edit the message to remove the keyboard and show confirmation
+	if err := ctx.EditMessage(editedText, &tgx.EditMessageOptions{ReplyMarkup: nil}); err != nil {
+		log.Printf("ERROR: Failed to edit message text for user %d: %v", chatId, err)
+	}
+	if onboarding {
+		if err := sendOnboardingStep(bot, user); err != nil {
+			log.Printf("ERROR: Failed to send next onboarding step to user %d: %v", chatId, err)
+		}
+	}
+
+	return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{Text: editedText, ShowAlert: false}) // Show as toast
+}
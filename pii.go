@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/harshyadavone/tgx"
+	"github.com/harshyadavone/tgx/models"
+)
+
+// What the pii filter does with contact details, set with PII_POLICY.
+const (
+	PIIPolicyWarn    = "warn"
+	PIIPolicyMask    = "mask"
+	PIIPolicyConfirm = "confirm"
+	PIIPolicyBlock   = "block"
+)
+
+const piiMask = "[hidden]"
+
+var (
+	emailPattern        = regexp.MustCompile(`[\w.+-]+@[\w-]+\.[\w.-]+`)
+	telegramLinkPattern = regexp.MustCompile(`(?i)(https?://)?(t|telegram)\.(me|dog)/\S+`)
+	usernamePattern     = regexp.MustCompile(`@[A-Za-z][A-Za-z0-9_]{4,31}`)
+	// phonePattern finds runs of digits that may be a phone number, which
+	// isPhoneNumber then checks.
+	phonePattern = regexp.MustCompile(`\+?\d[\d\s().-]{7,}\d`)
+	datePattern  = regexp.MustCompile(`\b(\d{1,2}[./-]\d{1,2}[./-]\d{4}|\d{4}[./-]\d{1,2}[./-]\d{1,2})\b`)
+)
+
+// Emails go first so their domain is not mistaken for a username.
+var piiPatterns = []*regexp.Regexp{emailPattern, telegramLinkPattern, usernamePattern}
+
+// piiKinds are messages that are contact details by themselves.
+var piiKinds = []string{"Contact", "Location", "Venue"}
+
+// isPhoneNumber reports whether a match of phonePattern has enough digits
+// to be a phone number and is not a date.
+func isPhoneNumber(s string) bool {
+	digits := 0
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+	return digits >= 9 && !datePattern.MatchString(s)
+}
+
+// containsPII reports whether the text or its entities carry a phone number,
+// username, email address or Telegram link.
+func containsPII(text string, entities []MessageEntity) bool {
+	for _, entity := range entities {
+		switch entity.Type {
+		case "mention", "text_mention", "email", "phone_number":
+			return true
+		case "text_link":
+			if telegramLinkPattern.MatchString(entity.URL) {
+				return true
+			}
+		}
+	}
+	for _, pattern := range piiPatterns {
+		if pattern.MatchString(text) {
+			return true
+		}
+	}
+	return slices.ContainsFunc(phonePattern.FindAllString(text, -1), isPhoneNumber)
+}
+
+func maskPII(text string) string {
+	for _, pattern := range piiPatterns {
+		text = pattern.ReplaceAllString(text, piiMask)
+	}
+	return phonePattern.ReplaceAllStringFunc(text, func(s string) string {
+		if isPhoneNumber(s) {
+			return piiMask
+		}
+		return s
+	})
+}
+
+func piiFilter(cfg Config) RelayFilter {
+	return func(m *OutgoingMessage) FilterResult {
+		shared := slices.Contains(piiKinds, m.Kind)
+		if m.Confirmed || !shared && !containsPII(m.Text, m.Entities) {
+			return FilterResult{}
+		}
+		m.Sender.PIICount++
+
+		switch cfg.PIIPolicy {
+		case PIIPolicyMask:
+			if shared {
+				// A shared contact or location can't be masked.
+				return FilterResult{Action: FilterBlock, Reason: "pii " + m.Kind, Notice: MessagePIIBlocked}
+			}
+			m.Text = maskPII(m.Text)
+			m.Modified = true
+			return FilterResult{Action: FilterFlag, Reason: "pii masked", Notice: MessagePIIMasked}
+		case PIIPolicyConfirm:
+			return FilterResult{Action: FilterHold, Reason: "pii held", Notice: MessagePIIConfirm}
+		case PIIPolicyBlock:
+			return FilterResult{Action: FilterBlock, Reason: "pii", Notice: MessagePIIBlocked}
+		default:
+			return FilterResult{Action: FilterFlag, Reason: "pii", Notice: MessagePIIWarning}
+		}
+	}
+}
+
+// holdMessage keeps a message back until the sender confirms it.
+func holdMessage(b *tgx.Bot, m *OutgoingMessage, notice string) error {
+	held, err := json.Marshal(m.Msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode held message: %w", err)
+	}
+	m.Sender.HeldMessage = string(held)
+	if err := UpdateUser(context.Background(), m.Sender); err != nil {
+		return err
+	}
+
+	return b.SendMessageWithOpts(&tgx.SendMessageRequest{
+		ChatId:      m.Sender.ChatId,
+		Text:        notice,
+		ReplyMarkup: models.InlineKeyboardMarkup{InlineKeyboard: inlineKeyboardConfirmSend},
+	})
+}
+
+// HandleHeldMessage drops the message the user was asked to confirm, or
+// sends it on through the rest of the relay filters and media consent.
+func HandleHeldMessage(ctx *tgx.CallbackContext, send bool) error {
+	chatId := ctx.GetChatID()
+	bg := context.Background()
+
+	user, err := GetUser(bg, chatId)
+	if err != nil || user.HeldMessage == "" {
+		ctx.EditMessage(MessageHeldMessageGone, nil)
+		return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{})
+	}
+
+	var msg Message
+	if err := json.Unmarshal([]byte(user.HeldMessage), &msg); err != nil {
+		log.Printf("ERROR: Failed to decode held message for user %d: %v", chatId, err)
+		send = false
+	}
+	user.HeldMessage = ""
+	if err := UpdateUser(bg, user); err != nil {
+		log.Printf("ERROR: Failed to clear held message for user %d: %v", chatId, err)
+		return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{Text: MessageErrSomethingWentWrong, ShowAlert: true})
+	}
+
+	if !send {
+		ctx.EditMessage(MessageHeldMessageDropped, nil)
+		return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{})
+	}
+
+	if !user.IsConnected || user.Partner == 0 {
+		ctx.EditMessage(MessageNotConnected, nil)
+		return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{})
+	}
+
+	ctx.AnswerCallback(&tgx.CallbackAnswerOptions{})
+	m := newOutgoingMessage(user, &msg)
+	m.Confirmed = true
+	sent, err := relayOutgoing(bg, bot, m)
+	if err != nil {
+		log.Printf("ERROR: Failed to send held message %d for user %d: %v", msg.MessageId, chatId, err)
+		return ctx.EditMessage(MessageErrSomethingWentWrong, nil)
+	}
+	if sent {
+		return ctx.EditMessage(MessageHeldMessageSent, nil)
+	}
+	return ctx.EditMessage(MessageHeldMessageConfirmed, nil)
+}
+
+func parsePIIPolicy(value string) string {
+	switch policy := strings.ToLower(value); policy {
+	case PIIPolicyWarn, PIIPolicyMask, PIIPolicyConfirm, PIIPolicyBlock:
+		return policy
+	}
+	log.Printf("WARN: Invalid PII_POLICY %q, using %q", value, PIIPolicyWarn)
+	return PIIPolicyWarn
+}
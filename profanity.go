@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/harshyadavone/anonymous_chat/store"
+)
+
+// What the profanity filter does with a match, set with PROFANITY_ACTION.
+const (
+	ProfanityActionMask   = "mask"
+	ProfanityActionBlock  = "block"
+	ProfanityActionReport = "report"
+)
+
+//go:embed wordlists/*.txt
+var defaultWordLists embed.FS
+
+// wordListEntry is a banned word or phrase as a sequence of normalized words.
+type wordListEntry []string
+
+// token is a normalized word and where it came from in the original text.
+type token struct {
+	word       string
+	start, end int
+	joined     bool // made up of single letters, see tokenize
+}
+
+// foldTable maps look-alike characters onto the plain letter they imitate.
+var foldTable = map[rune]rune{
+	// Leetspeak
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b',
+	'@': 'a', '$': 's', '!': 'i', '|': 'l',
+	// Cyrillic and Greek look-alikes
+	'а': 'a', 'в': 'b', 'е': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o',
+	'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'і': 'i', 'ј': 'j',
+	'α': 'a', 'ε': 'e', 'ι': 'i', 'κ': 'k', 'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u',
+	// Accented Latin letters
+	'à': 'a', 'á': 'a', 'â': 'a', 'ã': 'a', 'ä': 'a', 'å': 'a',
+	'è': 'e', 'é': 'e', 'ê': 'e', 'ë': 'e',
+	'ì': 'i', 'í': 'i', 'î': 'i', 'ï': 'i',
+	'ò': 'o', 'ó': 'o', 'ô': 'o', 'õ': 'o', 'ö': 'o', 'ø': 'o',
+	'ù': 'u', 'ú': 'u', 'û': 'u', 'ü': 'u',
+	'ñ': 'n', 'ç': 'c', 'ý': 'y', 'ÿ': 'y', 'ß': 's',
+}
+
+const leetSymbols = "@$!|"
+
+// isWordRune reports whether r is part of a word. Marks count, since scripts
+// like Devanagari write vowels and the virama as marks inside words.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
+
+// foldRune lowercases r and maps look-alikes onto the letter they imitate.
+// It returns -1 for characters that should be dropped.
+func foldRune(r rune) rune {
+	switch {
+	case r == '\u200b' || r == '\u200c' || r == '\u200d' || r == '\u2060' || r == '\ufeff' || r == '\u00ad':
+		// Zero-width characters and soft hyphens hidden inside words
+		return -1
+	case r >= '\u0300' && r <= '\u036f':
+		// Combining accents, as in a decomposed "é" or "f̶u̶c̶k̶"
+		return -1
+	case r >= '\uff01' && r <= '\uff5e':
+		// Fullwidth forms of ASCII
+		r -= 0xfee0
+	}
+	r = unicode.ToLower(r)
+	if folded, ok := foldTable[r]; ok {
+		return folded
+	}
+	return r
+}
+
+// tokenize splits text into normalized words. Runs of single letters are
+// joined, so spaced out words like "f u c k" or "f.u.c.k" become one word.
+func tokenize(text string) []token {
+	var tokens []token
+	var word strings.Builder
+	start := -1
+
+	flush := func(end int) {
+		if start < 0 {
+			return
+		}
+		tokens = append(tokens, token{word: word.String(), start: start, end: end})
+		word.Reset()
+		start = -1
+	}
+
+	type char struct {
+		offset int
+		r      rune
+	}
+	var chars []char
+	for i, r := range text {
+		chars = append(chars, char{i, r})
+	}
+
+	for n, c := range chars {
+		i, r := c.offset, c.r
+		folded := foldRune(r)
+		if folded == -1 {
+			continue
+		}
+		// Symbols only stand in for letters inside a word, "sh!t" but not "shit!".
+		if strings.ContainsRune(leetSymbols, r) && (n+1 == len(chars) || !isWordRune(foldRune(chars[n+1].r))) {
+			flush(i)
+			continue
+		}
+		if isWordRune(folded) {
+			if start < 0 {
+				start = i
+			}
+			word.WriteRune(folded)
+			continue
+		}
+		flush(i)
+	}
+	flush(len(text))
+
+	var joined []token
+	for i := 0; i < len(tokens); i++ {
+		if utf8.RuneCountInString(tokens[i].word) != 1 {
+			joined = append(joined, tokens[i])
+			continue
+		}
+		run := tokens[i]
+		for i+1 < len(tokens) && utf8.RuneCountInString(tokens[i+1].word) == 1 {
+			i++
+			run.word += tokens[i].word
+			run.end = tokens[i].end
+			run.joined = true
+		}
+		joined = append(joined, run)
+	}
+	return joined
+}
+
+// squeeze collapses repeated letters, so "fuuuck" and "fuck" compare equal.
+func squeeze(word string) (string, bool) {
+	var b strings.Builder
+	var last rune = -1
+	count, stretched := 0, false
+	for _, r := range word {
+		if r == last {
+			count++
+			if count >= 3 {
+				stretched = true
+			}
+			continue
+		}
+		last, count = r, 1
+		b.WriteRune(r)
+	}
+	return b.String(), stretched
+}
+
+// wordMatches compares a word of a message with a word of the list. Repeated
+// letters only count as the same word when they are clearly stretched, so
+// that "as" does not match "ass".
+func wordMatches(word, banned string) bool {
+	if word == banned {
+		return true
+	}
+	squeezed, stretched := squeeze(word)
+	if !stretched {
+		return false
+	}
+	bannedSqueezed, _ := squeeze(banned)
+	return squeezed == bannedSqueezed
+}
+
+// findProfanity returns the byte ranges of the text that match the word list.
+func findProfanity(text string, list []wordListEntry) [][2]int {
+	tokens := tokenize(text)
+	var matches [][2]int
+	for i := range tokens {
+		for _, entry := range list {
+			if i+len(entry) > len(tokens) {
+				continue
+			}
+			matched := true
+			for j, banned := range entry {
+				if !wordMatches(tokens[i+j].word, banned) {
+					matched = false
+					break
+				}
+			}
+			// A joined run can pick up a real one letter word next to it, as
+			// in "a f.u.c.k", so it only has to contain a banned word.
+			if !matched && tokens[i].joined && len(entry) == 1 {
+				matched = strings.Contains(tokens[i].word, entry[0])
+			}
+			if matched {
+				matches = append(matches, [2]int{tokens[i].start, tokens[i+len(entry)-1].end})
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// maskRanges replaces every range of the text with asterisks.
+func maskRanges(text string, ranges [][2]int) string {
+	var b strings.Builder
+	last := 0
+	for _, r := range ranges {
+		if r[0] < last {
+			continue
+		}
+		b.WriteString(text[last:r[0]])
+		b.WriteString(strings.Repeat("*", utf8.RuneCountInString(text[r[0]:r[1]])))
+		last = r[1]
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+func profanityFilter(cfg Config) RelayFilter {
+	list := loadWordLists(cfg.WordListDir, cfg.WordListLanguages)
+	log.Printf("LOG: Loaded %d banned words and phrases.", len(list))
+
+	return func(m *OutgoingMessage) FilterResult {
+		matches := findProfanity(m.Text, list)
+		if len(matches) == 0 {
+			return FilterResult{}
+		}
+
+		sender := m.Sender
+		sender.ProfanityCount++
+		// Repeat offenders are reported automatically, which feeds the same
+		// report score /report does.
+		if cfg.ProfanityReportEvery > 0 && sender.ProfanityCount%cfg.ProfanityReportEvery == 0 {
+			reportProfanity(sender)
+			log.Printf("LOG: User %d auto-reported after %d profanity offences.", sender.ChatId, sender.ProfanityCount)
+		}
+
+		switch cfg.ProfanityAction {
+		case ProfanityActionBlock:
+			return FilterResult{Action: FilterBlock, Reason: "profanity", Notice: MessageProfanityBlocked}
+		case ProfanityActionReport:
+			reportProfanity(sender)
+			return FilterResult{Action: FilterBlock, Reason: "profanity reported", Notice: MessageProfanityReported}
+		default:
+			m.Text = maskRanges(m.Text, matches)
+			m.Modified = true
+			return FilterResult{Action: FilterFlag, Reason: "profanity masked"}
+		}
+	}
+}
+
+// reportProfanity files a report by the bot against the sender. Like any
+// reporter the bot reports a user at most once per session.
+func reportProfanity(sender *store.User) {
+	_, err := fileReport(context.Background(), nil, sender, sender.SessionId, ReportReasonProfanity)
+	if err != nil && !errors.Is(err, store.ErrDuplicateReport) {
+		log.Printf("ERROR: Failed to report profanity of user %d: %v", sender.ChatId, err)
+	}
+}
+
+// loadWordLists reads <language>.txt for each language from dir, or from the
+// lists built into the binary when dir is empty. Without languages every list
+// found is loaded.
+func loadWordLists(dir string, languages []string) []wordListEntry {
+	var fsys fs.FS = defaultWordLists
+	root := "wordlists"
+	if dir != "" {
+		fsys, root = os.DirFS(dir), "."
+	}
+
+	if len(languages) == 0 {
+		paths, err := fs.Glob(fsys, path.Join(root, "*.txt"))
+		if err != nil {
+			log.Printf("ERROR: Failed to list word lists: %v", err)
+		}
+		for _, p := range paths {
+			languages = append(languages, strings.TrimSuffix(path.Base(p), ".txt"))
+		}
+	}
+
+	var list []wordListEntry
+	for _, language := range languages {
+		f, err := fsys.Open(path.Join(root, language+".txt"))
+		if err != nil {
+			log.Printf("WARN: No word list for language %q: %v", language, err)
+			continue
+		}
+		entries, err := parseWordList(f)
+		f.Close()
+		if err != nil {
+			log.Printf("ERROR: Failed to read word list %q: %v", language, err)
+			continue
+		}
+		list = append(list, entries...)
+	}
+	return list
+}
+
+func parseWordList(r io.Reader) ([]wordListEntry, error) {
+	var entries []wordListEntry
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var entry wordListEntry
+		for _, t := range tokenize(line) {
+			entry = append(entry, t.word)
+		}
+		if len(entry) > 0 {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan word list: %w", err)
+	}
+	return entries, nil
+}
+
+func parseProfanityAction(value string) string {
+	switch action := strings.ToLower(value); action {
+	case ProfanityActionMask, ProfanityActionBlock, ProfanityActionReport:
+		return action
+	}
+	log.Printf("WARN: Invalid PROFANITY_ACTION %q, using %q", value, ProfanityActionMask)
+	return ProfanityActionMask
+}
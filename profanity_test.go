@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"words", "Hello, World!", []string{"hello", "world"}},
+		{"spaced out", "f u c k", []string{"fuck"}},
+		{"dotted", "f.u.c.k", []string{"fuck"}},
+		{"leetspeak", "5h1t", []string{"shit"}},
+		{"symbol inside a word", "sh!t happens!", []string{"shit", "happens"}},
+		{"fullwidth", "ｆｕｃｋ", []string{"fuck"}},
+		{"zero width space", "fu\u200bck", []string{"fuck"}},
+		{"decomposed accent", "fu\u0301ck", []string{"fuck"}},
+		{"strikethrough", "f\u0336u\u0336c\u0336k\u0336", []string{"fuck"}},
+		{"cyrillic look-alikes", "sh\u0456t", []string{"shit"}},
+		{"devanagari vowel signs", "मैं ठीक हूँ", []string{"मैं", "ठीक", "हूँ"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, tok := range tokenize(tt.text) {
+				got = append(got, tok.word)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWordMatches(t *testing.T) {
+	tests := []struct {
+		word, banned string
+		want         bool
+	}{
+		{"fuck", "fuck", true},
+		{"fuuuck", "fuck", true},
+		{"fuuck", "fuck", false},
+		{"asss", "ass", true},
+		{"as", "ass", false},
+		{"fck", "fuck", false},
+		{"class", "ass", false},
+	}
+	for _, tt := range tests {
+		if got := wordMatches(tt.word, tt.banned); got != tt.want {
+			t.Errorf("wordMatches(%q, %q) = %v, want %v", tt.word, tt.banned, got, tt.want)
+		}
+	}
+}
+
+func TestFindProfanity(t *testing.T) {
+	list := []wordListEntry{{"fuck"}, {"bull", "shit"}}
+	tests := []struct {
+		name string
+		text string
+		want [][2]int
+	}{
+		{"plain", "what the fuck", [][2]int{{9, 13}}},
+		{"dotted", "what the f.u.c.k!", [][2]int{{9, 16}}},
+		{"stretched", "FUUUCK", [][2]int{{0, 6}}},
+		{"joined with a real word", "a f u c k", [][2]int{{0, 9}}},
+		{"phrase", "bull shit happens", [][2]int{{0, 9}}},
+		{"part of a phrase", "bull market", nil},
+		{"inside a longer word", "fuckery is not listed", nil},
+		{"similar word", "as you wish", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findProfanity(tt.text, list); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findProfanity(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/harshyadavone/anonymous_chat/store"
+	"github.com/harshyadavone/tgx"
+	"github.com/harshyadavone/tgx/models"
+)
+
+// askForRatings asks both participants of a session that just ended to rate
+// each other. Rating is optional, the prompt can simply be ignored.
+func askForRatings(b *tgx.Bot, sessionId string, chatId, partnerId int64) {
+	if err := userStore.CreateRatings(context.Background(), sessionId, chatId, partnerId); err != nil {
+		log.Printf("ERROR: Failed to create ratings for session %s: %v", sessionId, err)
+		return
+	}
+
+	keyboard := [][]models.InlineKeyboardButton{{
+		{Text: "👍", CallbackData: CallbackRateUp + sessionId},
+		{Text: "👎", CallbackData: CallbackRateDown + sessionId},
+	}}
+	for _, id := range []int64{chatId, partnerId} {
+		err := b.SendMessageWithOpts(&tgx.SendMessageRequest{
+			ChatId:      id,
+			Text:        MessageRatePartner,
+			ReplyMarkup: models.InlineKeyboardMarkup{InlineKeyboard: keyboard},
+		})
+		if err != nil {
+			log.Printf("ERROR: Failed to ask user %d for a rating: %v", id, err)
+		}
+	}
+}
+
+// HandleRating records a 👍 or 👎 for the partner of a past session and adds
+// it to the partner's rating.
+func HandleRating(b *tgx.Bot, query *CallbackQuery) error {
+	if query.Message == nil {
+		return answerCallbackQuery(query.Id, "", false)
+	}
+	chatId := query.Message.Chat.Id
+	ctx := context.Background()
+
+	value, sessionId := 1, strings.TrimPrefix(query.Data, CallbackRateUp)
+	if strings.HasPrefix(query.Data, CallbackRateDown) {
+		value, sessionId = -1, strings.TrimPrefix(query.Data, CallbackRateDown)
+	}
+
+	rating, err := userStore.RateSession(ctx, sessionId, chatId, value)
+	if errors.Is(err, store.ErrAlreadyRated) {
+		editMessageText(chatId, query.Message.MessageId, MessageAlreadyRated)
+		return answerCallbackQuery(query.Id, "", false)
+	}
+	if errors.Is(err, store.ErrRatingExpired) {
+		editMessageText(chatId, query.Message.MessageId, MessageRatingExpired)
+		return answerCallbackQuery(query.Id, "", false)
+	}
+	if err != nil {
+		return err
+	}
+
+	partner, err := GetUser(ctx, rating.RatedChatId)
+	if err == nil {
+		if value > 0 {
+			partner.RatingsUp++
+		} else {
+			partner.RatingsDown++
+		}
+		if err := UpdateUser(ctx, partner); err != nil {
+			log.Printf("ERROR: Failed to update rating of user %d: %v", partner.ChatId, err)
+		}
+	}
+	log.Printf("LOG: User %d rated their partner in session %s with %d.", chatId, sessionId, value)
+
+	editMessageText(chatId, query.Message.MessageId, MessageRatingThanks)
+	return answerCallbackQuery(query.Id, "", false)
+}
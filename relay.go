@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/harshyadavone/tgx"
+
+	"github.com/harshyadavone/anonymous_chat/store"
+)
+
+// HandleRelay delivers a non-command message to the sender's partner and
+// records the message IDs on both sides so the copy can be found later.
+func HandleRelay(b *tgx.Bot, msg *Message) error {
+	chatId := msg.Chat.Id
+	ctx := context.Background()
+
+	user, errMsg := getConnectedUser(ctx, chatId)
+	if errMsg != "" {
+		return b.SendMessage(chatId, errMsg)
+	}
+
+	if user.IsBanned(time.Now()) {
+		// Banned while in the chat, by reports or a moderator.
+		log.Printf("LOG: Ending chat of banned user %d.", chatId)
+		HandleStop(b, chatId)
+		return b.SendMessage(chatId, banMessage(user))
+	}
+
+	m := newOutgoingMessage(user, msg)
+	// Someone in crisis gets the helpline resources even when they write
+	// faster than the flood limit lets through.
+	checkCrisis(ctx, user, m.Text)
+	if !checkFloodLimit(ctx, b, user, msg) {
+		return nil
+	}
+
+	if user.ViewOncePending {
+		// Answering counts as having seen the partner's view-once media.
+		removeViewOnce(ctx, user)
+	}
+
+	if !checkSpamFingerprint(ctx, b, user, m.Text) {
+		return nil
+	}
+	if !checkTrust(b, m) {
+		return nil
+	}
+	_, err := relayOutgoing(ctx, b, m)
+	return err
+}
+
+// relayOutgoing runs a message through the relay filters and media consent
+// and delivers it to the partner. It reports whether the message was
+// delivered now.
+func relayOutgoing(ctx context.Context, b *tgx.Bot, m *OutgoingMessage) (bool, error) {
+	user, msg := m.Sender, m.Msg
+	chatId := user.ChatId
+
+	results, deliver := applyRelayFilters(m)
+	for _, result := range results {
+		if result.Action != FilterPass {
+			flagMessage(ctx, user, result.Reason)
+		}
+		if result.Action == FilterHold {
+			return false, holdMessage(b, m, result.Notice)
+		}
+		if result.Notice != "" {
+			b.SendMessage(chatId, result.Notice)
+		}
+	}
+	if user.IsBanned(time.Now()) {
+		// A report filed by a filter just got the sender banned, and
+		// fileReport already told them.
+		log.Printf("LOG: Ending chat of user %d, banned over message %d.", chatId, msg.MessageId)
+		if err := UpdateUser(ctx, user); err != nil {
+			log.Printf("ERROR: Failed to save ban of user %d: %v", chatId, err)
+		}
+		return false, HandleStop(b, chatId)
+	}
+	if !deliver {
+		log.Printf("LOG: Message %d from %d was blocked by the relay filters.", msg.MessageId, chatId)
+		return false, nil
+	}
+	if !checkMediaConsent(ctx, b, m) {
+		return false, nil
+	}
+
+	var err error
+	if msg.MediaGroupId != "" {
+		err = relayAlbumPart(ctx, m)
+	} else {
+		err = relayCopy(ctx, m)
+	}
+	if isChatGone(err) {
+		log.Printf("LOG: Partner %d of user %d can no longer be reached: %v", user.Partner, chatId, err)
+		return false, HandlePartnerGone(b, user)
+	}
+	return err == nil, err
+}
+
+// checkFloodLimit takes a token from the sender's text or media bucket and
+// reports whether the message may be relayed. If the store can't be reached
+// the message is let through rather than dropping the conversation.
+func checkFloodLimit(ctx context.Context, b *tgx.Bot, user *store.User, msg *Message) bool {
+	bucket, limit := "text", config.TextRateLimit
+	if messageKind(msg) != "Text" {
+		bucket, limit = "media", config.MediaRateLimit
+	}
+
+	result, err := userStore.TakeToken(ctx, user.ChatId, bucket, limit)
+	if err != nil {
+		log.Printf("WARN: Flood check failed for user %d: %v", user.ChatId, err)
+		return true
+	}
+	if result.Allowed {
+		return true
+	}
+
+	log.Printf("LOG: User %d is over the %s rate limit.", user.ChatId, bucket)
+	if result.Notify {
+		seconds := int(math.Ceil(result.RetryAfter.Seconds()))
+		b.SendMessage(user.ChatId, fmt.Sprintf(MessageSlowDown, seconds))
+	}
+	return false
+}
+
+// flagMessage records a message that a relay filter flagged or blocked.
+func flagMessage(ctx context.Context, user *store.User, reason string) {
+	log.Printf("FLAG: Message from user %d flagged: %s", user.ChatId, reason)
+	user.FlagCount++
+	if err := UpdateUser(ctx, user); err != nil {
+		log.Printf("WARN: Failed to update flag count for user %d: %v", user.ChatId, err)
+	}
+}
+
+func relayOptionsFor(user *store.User) relayOptions {
+	return relayOptions{
+		ProtectContent: user.ProtectContent || user.ViewOnce,
+		Spoiler:        user.ViewOnce,
+	}
+}
+
+// relayCopy delivers a single message to the user's partner, as a copy
+// unless the filters changed its text.
+func relayCopy(ctx context.Context, m *OutgoingMessage) error {
+	user, msg := m.Sender, m.Msg
+	opts := relayOptionsFor(user)
+	if m.Modified {
+		opts.Caption = &m.Text
+	}
+
+	var copyId int64
+	var err error
+	viewOnce := user.ViewOnce && (len(msg.Photo) > 0 || msg.Video != nil)
+	switch {
+	case viewOnce && msg.Video != nil:
+		copyId, err = sendMedia(user.Partner, "video", msg.Video.FileId, m.Text, opts)
+	case viewOnce:
+		copyId, err = sendMedia(user.Partner, "photo", msg.Photo[len(msg.Photo)-1].FileId, m.Text, opts)
+	case m.Modified && m.Kind == "Text":
+		copyId, err = sendText(user.Partner, m.Text, opts)
+	default:
+		copyId, err = copyMessage(user.Partner, user.ChatId, msg.MessageId, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	saveRelayedMessage(ctx, user, msg.MessageId, copyId, viewOnce)
+	if viewOnce {
+		markViewOncePending(ctx, user.Partner)
+	}
+	return nil
+}
+
+func saveRelayedMessage(ctx context.Context, user *store.User, messageId, copyId int64, viewOnce bool) {
+	err := userStore.SaveRelayedMessage(ctx, &store.RelayedMessage{
+		SessionId:     user.SessionId,
+		ChatId:        user.ChatId,
+		MessageId:     messageId,
+		PeerChatId:    user.Partner,
+		PeerMessageId: copyId,
+		ViewOnce:      viewOnce,
+	})
+	if err != nil {
+		// The message was delivered, only /unsend and wiping will miss it.
+		log.Printf("WARN: Failed to save relayed message %d for user %d: %v", messageId, user.ChatId, err)
+	}
+}
+
+// markViewOncePending notes that the partner's chat now holds view-once media.
+func markViewOncePending(ctx context.Context, partnerChatId int64) {
+	partner, err := GetUser(ctx, partnerChatId)
+	if err != nil {
+		log.Printf("WARN: Could not load partner %d to mark view-once media: %v", partnerChatId, err)
+		return
+	}
+	if partner.ViewOncePending {
+		return
+	}
+	partner.ViewOncePending = true
+	if err := UpdateUser(ctx, partner); err != nil {
+		log.Printf("WARN: Failed to mark view-once media for user %d: %v", partnerChatId, err)
+	}
+}
+
+// removeViewOnce deletes the view-once media the partner sent into the
+// user's chat during the current session.
+func removeViewOnce(ctx context.Context, user *store.User) {
+	deleteViewOnce(ctx, user.SessionId, user.ChatId)
+	user.ViewOncePending = false
+	if err := UpdateUser(ctx, user); err != nil {
+		log.Printf("WARN: Failed to clear view-once flag for user %d: %v", user.ChatId, err)
+	}
+}
+
+func deleteViewOnce(ctx context.Context, sessionId string, chatId int64) {
+	if sessionId == "" {
+		return
+	}
+
+	records, err := userStore.GetViewOnceMessages(ctx, sessionId, chatId)
+	if err != nil {
+		log.Printf("ERROR: Failed to load view-once messages for user %d: %v", chatId, err)
+		return
+	}
+	for i := range records {
+		if err := deleteMessage(records[i].ChatId, records[i].MessageId); err != nil {
+			log.Printf("WARN: Failed to delete view-once message %d in chat %d: %v", records[i].MessageId, records[i].ChatId, err)
+		}
+		if err := userStore.ClearViewOnce(ctx, &records[i]); err != nil {
+			log.Printf("WARN: %v", err)
+		}
+	}
+}
+
+// HandleReaction mirrors a reaction onto the partner's side of the message,
+// whether it was put on the user's own message or on a relayed copy.
+func HandleReaction(reaction *MessageReactionUpdated) error {
+	chatId := reaction.Chat.Id
+	ctx := context.Background()
+
+	user, errMsg := getConnectedUser(ctx, chatId)
+	if errMsg != "" {
+		return nil
+	}
+
+	record, err := userStore.GetRelayedMessage(ctx, user.SessionId, chatId, reaction.MessageId)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		log.Printf("LOG: Reaction from %d on message %d that was not relayed, ignoring.", chatId, reaction.MessageId)
+		return nil
+	}
+
+	// Bots can only set a single, regular emoji reaction on a message.
+	emoji := []ReactionType{}
+	for _, r := range reaction.NewReaction {
+		if r.Type == "emoji" {
+			emoji = append(emoji, ReactionType{Type: r.Type, Emoji: r.Emoji})
+			break
+		}
+	}
+
+	return setMessageReaction(record.PeerChatId, record.PeerMessageId, emoji)
+}
+
+// HandleUnsend deletes the partner's copy of the message the command replies to.
+func HandleUnsend(b *tgx.Bot, msg *Message) error {
+	chatId := msg.Chat.Id
+	log.Printf("LOG: HandleUnsend called for ChatID: %d", chatId)
+	ctx := context.Background()
+
+	if msg.ReplyToMessage == nil {
+		return b.SendMessage(chatId, MessageUnsendUsage)
+	}
+
+	user, errMsg := getConnectedUser(ctx, chatId)
+	if errMsg != "" {
+		return b.SendMessage(chatId, errMsg)
+	}
+
+	record, err := userStore.GetRelayedMessage(ctx, user.SessionId, chatId, msg.ReplyToMessage.MessageId)
+	if err != nil {
+		return err
+	}
+	if record == nil || !record.Outgoing {
+		return b.SendMessage(chatId, MessageUnsendNotFound)
+	}
+
+	if err := deleteMessage(record.PeerChatId, record.PeerMessageId); err != nil {
+		log.Printf("ERROR: Failed to delete message %d in chat %d: %v", record.PeerMessageId, record.PeerChatId, err)
+		return b.SendMessage(chatId, MessageUnsendFailed)
+	}
+
+	return b.SendMessage(chatId, MessageUnsent)
+}
+
+func HandleAutoWipe(ctx *tgx.Context) error {
+	user, err := GetUser(context.Background(), ctx.ChatID)
+	if errors.Is(err, store.ErrUserNotFound) {
+		user = &store.User{ChatId: ctx.ChatID}
+	} else if err != nil {
+		log.Printf("ERROR: Failed to get user %d: %v", ctx.ChatID, err)
+		return ctx.Reply(MessageErrSomethingWentWrong)
+	}
+
+	enabled, ok := parseToggle(ctx.Args, user.WipeOnEnd)
+	if !ok {
+		return ctx.Reply(MessageInvalidAutoWipe)
+	}
+	user.WipeOnEnd = enabled
+
+	if err := UpdateUser(context.Background(), user); err != nil {
+		return ctx.Reply(MessageErrSomethingWentWrong)
+	}
+
+	if user.WipeOnEnd {
+		return ctx.Reply(MessageAutoWipeOn)
+	}
+	return ctx.Reply(MessageAutoWipeOff)
+}
+
+func HandleProtect(ctx *tgx.Context) error {
+	user, err := GetUser(context.Background(), ctx.ChatID)
+	if errors.Is(err, store.ErrUserNotFound) {
+		user = &store.User{ChatId: ctx.ChatID}
+	} else if err != nil {
+		log.Printf("ERROR: Failed to get user %d: %v", ctx.ChatID, err)
+		return ctx.Reply(MessageErrSomethingWentWrong)
+	}
+
+	enabled, ok := parseToggle(ctx.Args, user.ProtectContent)
+	if !ok {
+		return ctx.Reply(MessageInvalidProtect)
+	}
+
+	user.ProtectContent = enabled
+	if err := UpdateUser(context.Background(), user); err != nil {
+		return ctx.Reply(MessageErrSomethingWentWrong)
+	}
+
+	if user.ProtectContent {
+		return ctx.Reply(MessageProtectOn)
+	}
+	return ctx.Reply(MessageProtectOff)
+}
+
+func HandleViewOnce(ctx *tgx.Context) error {
+	user, err := GetUser(context.Background(), ctx.ChatID)
+	if errors.Is(err, store.ErrUserNotFound) {
+		user = &store.User{ChatId: ctx.ChatID}
+	} else if err != nil {
+		log.Printf("ERROR: Failed to get user %d: %v", ctx.ChatID, err)
+		return ctx.Reply(MessageErrSomethingWentWrong)
+	}
+
+	enabled, ok := parseToggle(ctx.Args, user.ViewOnce)
+	if !ok {
+		return ctx.Reply(MessageInvalidViewOnce)
+	}
+
+	user.ViewOnce = enabled
+	if err := UpdateUser(context.Background(), user); err != nil {
+		return ctx.Reply(MessageErrSomethingWentWrong)
+	}
+
+	if user.ViewOnce {
+		return ctx.Reply(MessageViewOnceOn)
+	}
+	return ctx.Reply(MessageViewOnceOff)
+}
+
+// parseToggle reads an on/off argument. Without one the current value is
+// flipped. It reports false if the argument is not recognised.
+func parseToggle(args []string, current bool) (bool, bool) {
+	if len(args) == 0 {
+		return !current, true
+	}
+	switch strings.ToLower(args[0]) {
+	case "on":
+		return true, true
+	case "off":
+		return false, true
+	}
+	return current, false
+}
+
+// wipeSession deletes every relayed message of a session from both chats.
+func wipeSession(sessionId string) {
+	records, err := userStore.GetSessionMessages(context.Background(), sessionId)
+	if err != nil {
+		log.Printf("ERROR: Failed to load messages of session %s for wiping: %v", sessionId, err)
+		return
+	}
+
+	for _, record := range records {
+		if err := deleteMessage(record.ChatId, record.MessageId); err != nil {
+			log.Printf("WARN: Failed to delete message %d in chat %d: %v", record.MessageId, record.ChatId, err)
+		}
+	}
+	log.Printf("LOG: Wiped %d messages of session %s.", len(records), sessionId)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/harshyadavone/anonymous_chat/store"
+	"github.com/harshyadavone/tgx"
+)
+
+// Reasons a user can give for a report.
+const (
+	ReportReasonSpam       = "spam"
+	ReportReasonHarassment = "harassment"
+	ReportReasonSexual     = "sexual"
+	ReportReasonUnderage   = "underage"
+	ReportReasonScam       = "scam"
+	ReportReasonOther      = "other"
+
+	// ReportReasonProfanity is used for reports the profanity filter files.
+	ReportReasonProfanity = "profanity"
+)
+
+var reportReasons = []string{
+	ReportReasonSpam,
+	ReportReasonHarassment,
+	ReportReasonSexual,
+	ReportReasonUnderage,
+	ReportReasonScam,
+	ReportReasonOther,
+}
+
+// HandleReportReason files a report against the user's partner for the
+// reason picked from the /report keyboard and ends the chat.
+func HandleReportReason(ctx *tgx.CallbackContext, reason string) error {
+	chatId := ctx.GetChatID()
+	bg := context.Background()
+
+	user, err := GetUser(bg, chatId)
+	if err != nil || !user.IsConnected || user.Partner == 0 {
+		log.Printf("LOG: User %d picked a report reason but was not in a chat.", chatId)
+		ctx.EditMessage(MessageNotInChat, nil)
+		return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{})
+	}
+
+	partner, err := GetUser(bg, user.Partner)
+	if err != nil {
+		log.Printf("ERROR: Could not find partner %d to report for user %d.", user.Partner, chatId)
+		return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{Text: MessageErrSomethingWentWrong, ShowAlert: true})
+	}
+
+	_, err = fileReport(bg, user, partner, user.SessionId, reason)
+	if errors.Is(err, store.ErrDuplicateReport) {
+		ctx.EditMessage(MessageAlreadyReported, nil)
+		return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{})
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to save report of user %d against %d: %v", chatId, partner.ChatId, err)
+		return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{Text: MessageErrSomethingWentWrong, ShowAlert: true})
+	}
+	if err := UpdateUser(bg, partner); err != nil {
+		log.Printf("ERROR: Failed to update partner %d report score: %v", partner.ChatId, err)
+	}
+
+	// Disconnect the users
+	HandleStop(bot, chatId)
+
+	ctx.EditMessage(MessageReportConfirmation, nil)
+	return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{})
+}
+
+// fileReport stores a report against reported and adds it to their score. A
+// nil reporter files the report on behalf of the bot. The caller saves
+// reported.
+func fileReport(ctx context.Context, reporter, reported *store.User, sessionId, reason string) (*store.Report, error) {
+	report := &store.Report{
+		ReportedChatId: reported.ChatId,
+		SessionId:      sessionId,
+		Reason:         reason,
+		Weight:         1,
+	}
+	if reporter != nil {
+		report.ReporterChatId = reporter.ChatId
+		report.Weight = reporterCredibility(reporter)
+	}
+
+	existing, err := userStore.GetReport(ctx, reported.ChatId, sessionId, report.ReporterChatId, reason)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, store.ErrDuplicateReport
+	}
+
+	if reporter != nil {
+		report.EvidenceChatId, report.EvidenceMessageIds = collectEvidence(ctx, report)
+	}
+	if err := userStore.SaveReport(ctx, report); err != nil {
+		return nil, err
+	}
+
+	before := reportScore(reported)
+	addReportScore(reported, report.Weight)
+	log.Printf("LOG: User %d reported %d for %s with weight %.2f. Report score is now %.2f.",
+		report.ReporterChatId, reported.ChatId, reason, report.Weight, reported.ReportScore)
+	if config.ShadowPoolScore > 0 && reported.ReportScore >= config.ShadowPoolScore {
+		// Quietly cool them off among others with a bad reputation.
+		reported.ShadowUntil = time.Now().Add(config.ShadowPoolDuration).Unix()
+		log.Printf("LOG: User %d is in the shadow pool until %d.", reported.ChatId, reported.ShadowUntil)
+	}
+	if applyBanPolicy(reported, before, reported.ReportScore) {
+		bot.SendMessage(reported.ChatId, banMessage(reported))
+	}
+	notifyAdmins(report, reported)
+	return report, nil
+}
+
+// reporterCredibility weighs a report by how often the reporter's earlier
+// reports were upheld. A reporter without decided reports counts as 1, one
+// whose reports are always upheld approaches 2 and one whose reports are
+// always dismissed approaches 0.
+func reporterCredibility(reporter *store.User) float64 {
+	upheld := float64(reporter.ReportsUpheld)
+	decided := upheld + float64(reporter.ReportsDismissed)
+	return 2 * (upheld + 1) / (decided + 2)
+}
+
+// decayedScore returns a report score as of now, halving every
+// config.ReportHalfLife since it was last updated.
+func decayedScore(score float64, updatedAt int64, now time.Time) float64 {
+	if score == 0 || config.ReportHalfLife <= 0 {
+		return score
+	}
+	elapsed := now.Sub(time.Unix(updatedAt, 0))
+	return score * math.Pow(0.5, elapsed.Hours()/config.ReportHalfLife.Hours())
+}
+
+// reportScore is the current reputation score of a user from reports against
+// them. The higher it is, the worse their reputation.
+func reportScore(user *store.User) float64 {
+	return decayedScore(user.ReportScore, user.ReportScoreAt, time.Now())
+}
+
+// recomputeReportScore rebuilds the report score of a user from the reports
+// against them that were not dismissed. The caller saves the user.
+func recomputeReportScore(ctx context.Context, user *store.User) error {
+	reports, err := userStore.GetReports(ctx, user.ChatId)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	score := 0.0
+	for _, report := range reports {
+		if report.Status != store.ReportDismissed {
+			score += decayedScore(report.Weight, report.CreatedAt, now)
+		}
+	}
+	user.ReportScore = score
+	user.ReportScoreAt = now.Unix()
+	return nil
+}
+
+func addReportScore(user *store.User, weight float64) {
+	now := time.Now()
+	user.ReportScore = decayedScore(user.ReportScore, user.ReportScoreAt, now) + weight
+	user.ReportScoreAt = now.Unix()
+}
+
+// collectEvidence copies the last messages the reported user sent in the
+// session into the evidence chat, as the reporter received them, and returns
+// where they are. Relayed messages expire and can be wiped, the copies stay.
+func collectEvidence(ctx context.Context, report *store.Report) (int64, []int64) {
+	if config.EvidenceChatId == 0 || config.ReportEvidenceMessages <= 0 {
+		return 0, nil
+	}
+
+	records, err := userStore.GetSessionMessages(ctx, report.SessionId)
+	if err != nil {
+		log.Printf("ERROR: Failed to get messages of session %s for evidence: %v", report.SessionId, err)
+		return 0, nil
+	}
+
+	var sent []store.RelayedMessage
+	for _, record := range records {
+		if record.ChatId == report.ReportedChatId && record.Outgoing {
+			sent = append(sent, record)
+		}
+	}
+	if len(sent) == 0 {
+		return 0, nil
+	}
+	sort.Slice(sent, func(i, j int) bool { return sent[i].MessageId < sent[j].MessageId })
+	if len(sent) > config.ReportEvidenceMessages {
+		sent = sent[len(sent)-config.ReportEvidenceMessages:]
+	}
+
+	header := fmt.Sprintf(MessageEvidenceHeader, report.ReportedChatId, report.ReporterChatId, report.Reason)
+	if _, err := sendText(config.EvidenceChatId, header, relayOptions{}); err != nil {
+		log.Printf("ERROR: Failed to send evidence header to chat %d: %v", config.EvidenceChatId, err)
+		return 0, nil
+	}
+
+	var ids []int64
+	for _, record := range sent {
+		copyId, err := copyMessage(config.EvidenceChatId, record.PeerChatId, record.PeerMessageId, relayOptions{})
+		if err != nil {
+			// View-once media and unsent messages are gone from the chat.
+			log.Printf("WARN: Failed to copy message %d of chat %d as evidence: %v", record.PeerMessageId, record.PeerChatId, err)
+			continue
+		}
+		ids = append(ids, copyId)
+	}
+	return config.EvidenceChatId, ids
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/harshyadavone/anonymous_chat/store"
+)
+
+func TestReporterCredibility(t *testing.T) {
+	tests := []struct {
+		name              string
+		upheld, dismissed int
+		want              float64
+	}{
+		{"no decided reports", 0, 0, 1},
+		{"one upheld", 1, 0, 4.0 / 3},
+		{"one dismissed", 0, 1, 2.0 / 3},
+		{"as many upheld as dismissed", 5, 5, 1},
+		{"always upheld", 98, 0, 1.98},
+		{"always dismissed", 0, 98, 0.02},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reporter := &store.User{ReportsUpheld: tt.upheld, ReportsDismissed: tt.dismissed}
+			if got := reporterCredibility(reporter); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("reporterCredibility(%d upheld, %d dismissed) = %v, want %v", tt.upheld, tt.dismissed, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecayedScore(t *testing.T) {
+	defer func(halfLife time.Duration) { config.ReportHalfLife = halfLife }(config.ReportHalfLife)
+
+	now := time.Unix(1_700_000_000, 0)
+	day := 24 * time.Hour
+	tests := []struct {
+		name     string
+		halfLife time.Duration
+		score    float64
+		age      time.Duration
+		want     float64
+	}{
+		{"just updated", 30 * day, 4, 0, 4},
+		{"one half-life", 30 * day, 4, 30 * day, 2},
+		{"two half-lives", 30 * day, 4, 60 * day, 1},
+		{"half a half-life", 30 * day, 2, 15 * day, math.Sqrt2},
+		{"zero score", 30 * day, 0, 90 * day, 0},
+		{"decay turned off", 0, 4, 90 * day, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.ReportHalfLife = tt.halfLife
+			got := decayedScore(tt.score, now.Add(-tt.age).Unix(), now)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("decayedScore(%v, %v ago) = %v, want %v", tt.score, tt.age, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/harshyadavone/anonymous_chat/store"
+	"github.com/harshyadavone/tgx"
+	"github.com/harshyadavone/tgx/models"
+)
+
+// HandleReveal asks to swap Telegram accounts with the partner. Once both
+// partners asked, or one asked and the other accepted, each gets the other's
+// account. Requests belong to the session, so they lapse when it ends.
+func HandleReveal(ctx *tgx.Context) error {
+	chatId := ctx.ChatID
+	bg := context.Background()
+
+	user, errMsg := getConnectedUser(bg, chatId)
+	if errMsg != "" {
+		return ctx.Reply(errMsg)
+	}
+	if level := trustLevel(user, time.Now()); level < trustRevealLevel {
+		return ctx.Reply(fmt.Sprintf(MessageRevealLocked, trustLevelNames[trustRevealLevel]))
+	}
+	if user.RevealedSession == user.SessionId {
+		return ctx.Reply(MessageRevealDone)
+	}
+	if user.RevealSession == user.SessionId {
+		return ctx.Reply(MessageRevealPending)
+	}
+
+	partner, err := GetUser(bg, user.Partner)
+	if err != nil {
+		log.Printf("ERROR: Failed to get partner %d of user %d for reveal: %v", user.Partner, chatId, err)
+		return ctx.Reply(MessageErrSomethingWentWrong)
+	}
+	if partner.RevealDeclinedSession == user.SessionId {
+		return ctx.Reply(MessageRevealDeclined)
+	}
+
+	user.RevealSession = user.SessionId
+	if err := UpdateUser(bg, user); err != nil {
+		log.Printf("ERROR: Failed to save reveal request of user %d: %v", chatId, err)
+		return ctx.Reply(MessageErrSomethingWentWrong)
+	}
+	log.Printf("LOG: User %d asked to reveal their identity in session %s.", chatId, user.SessionId)
+
+	if partner.RevealSession == user.SessionId {
+		return revealIdentities(bot, user, partner)
+	}
+
+	err = bot.SendMessageWithOpts(&tgx.SendMessageRequest{
+		ChatId:      partner.ChatId,
+		Text:        MessageRevealRequest,
+		ReplyMarkup: models.InlineKeyboardMarkup{InlineKeyboard: inlineKeyboardReveal},
+	})
+	if err != nil {
+		return err
+	}
+	return ctx.Reply(MessageRevealRequested)
+}
+
+// HandleRevealAnswer accepts or declines the partner's request to swap
+// accounts.
+func HandleRevealAnswer(ctx *tgx.CallbackContext, accept bool) error {
+	chatId := ctx.GetChatID()
+	bg := context.Background()
+
+	user, errMsg := getConnectedUser(bg, chatId)
+	var partner *store.User
+	if errMsg == "" {
+		partner, _ = GetUser(bg, user.Partner)
+	}
+	if partner == nil || partner.RevealSession != user.SessionId {
+		ctx.EditMessage(MessageRevealExpired, nil)
+		return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{})
+	}
+	if user.RevealedSession == user.SessionId {
+		ctx.EditMessage(MessageRevealDone, nil)
+		return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{})
+	}
+
+	if !accept {
+		user.RevealDeclinedSession = user.SessionId
+		if err := UpdateUser(bg, user); err != nil {
+			log.Printf("ERROR: Failed to save reveal answer of user %d: %v", chatId, err)
+			return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{Text: MessageErrSomethingWentWrong, ShowAlert: true})
+		}
+		log.Printf("LOG: User %d declined to reveal their identity.", chatId)
+		ctx.EditMessage(MessageRevealYouDeclined, nil)
+		bot.SendMessage(partner.ChatId, MessageRevealDeclined)
+		return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{})
+	}
+
+	if trustLevel(user, time.Now()) < trustRevealLevel {
+		ctx.EditMessage(fmt.Sprintf(MessageRevealLocked, trustLevelNames[trustRevealLevel]), nil)
+		bot.SendMessage(partner.ChatId, MessageRevealPartnerLocked)
+		return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{})
+	}
+
+	user.RevealSession = user.SessionId
+	if err := UpdateUser(bg, user); err != nil {
+		log.Printf("ERROR: Failed to save reveal answer of user %d: %v", chatId, err)
+		return ctx.AnswerCallback(&tgx.CallbackAnswerOptions{Text: MessageErrSomethingWentWrong, ShowAlert: true})
+	}
+	ctx.EditMessage(MessageRevealYouAccepted, nil)
+	ctx.AnswerCallback(&tgx.CallbackAnswerOptions{})
+	return revealIdentities(bot, user, partner)
+}
+
+// revealIdentities sends each partner a link to the other's account, and
+// records the swap so the session can't reveal twice.
+func revealIdentities(b *tgx.Bot, user, partner *store.User) error {
+	ctx := context.Background()
+	for _, u := range []*store.User{user, partner} {
+		u.RevealedSession = user.SessionId
+		if err := UpdateUser(ctx, u); err != nil {
+			log.Printf("ERROR: Failed to save reveal of user %d: %v", u.ChatId, err)
+			return b.SendMessage(user.ChatId, MessageErrSomethingWentWrong)
+		}
+	}
+
+	log.Printf("LOG: Users %d and %d revealed their identities in session %s.", user.ChatId, partner.ChatId, user.SessionId)
+	for _, pair := range [][2]int64{{user.ChatId, partner.ChatId}, {partner.ChatId, user.ChatId}} {
+		to, about := pair[0], pair[1]
+		err := b.SendMessageWithOpts(&tgx.SendMessageRequest{
+			ChatId:    to,
+			Text:      fmt.Sprintf(MessageRevealed, identityLink(about)),
+			ParseMode: tgx.ParseModeHTML,
+		})
+		if err != nil {
+			log.Printf("ERROR: Failed to reveal identity of %d to %d: %v", about, to, err)
+		}
+	}
+	return nil
+}
+
+// identityLink is the @username of a user, or a tg://user link with their
+// name for users without one.
+func identityLink(chatId int64) string {
+	chat, err := getChat(chatId)
+	if err != nil {
+		log.Printf("WARN: Failed to get chat %d for reveal: %v", chatId, err)
+		chat = &ChatInfo{Id: chatId}
+	}
+	if chat.Username != "" {
+		return "@" + html.EscapeString(chat.Username)
+	}
+	name := strings.TrimSpace(chat.FirstName + " " + chat.LastName)
+	if name == "" {
+		name = "your partner"
+	}
+	return fmt.Sprintf(`<a href="tg://user?id=%d">%s</a>`, chatId, html.EscapeString(name))
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+
+	"github.com/harshyadavone/tgx"
+)
+
+// Every request to the Bot API, from tgx or from callAPI, goes through
+// retryTransport. It waits out 429 responses for as long as Telegram asks and
+// retries 5xx responses and connections that failed before the request was
+// sent with jittered backoff, but never past the deadline of the current
+// Lambda invocation. A request that may have reached Telegram is not sent
+// again, so a lost response can't deliver a message twice.
+
+const (
+	sendAttempts    = 4
+	sendBackoffBase = 250 * time.Millisecond
+	// sendAttemptTimeout bounds a single request, so a hung connection
+	// leaves time for the next attempt.
+	sendAttemptTimeout = 10 * time.Second
+	// sendDeadlineMargin is kept free at the end of an invocation so the
+	// handler can still answer after giving up on a send.
+	sendDeadlineMargin = time.Second
+)
+
+// Typed failures of a send that were not fixed by retrying.
+var (
+	// ErrChatGone means the user blocked the bot, deleted their account or
+	// the chat does not exist. Sending to them again will never work.
+	ErrChatGone = errors.New("chat is gone")
+	// ErrRateLimited means Telegram still refused the send for flooding when
+	// there was no time left to wait.
+	ErrRateLimited = errors.New("rate limited by Telegram")
+	// ErrTelegramUnavailable means Telegram kept failing or could not be reached.
+	ErrTelegramUnavailable = errors.New("Telegram is unavailable")
+	// ErrRejected covers every other request Telegram refused.
+	ErrRejected = errors.New("request rejected by Telegram")
+)
+
+// SendError is returned by callAPI when a request failed for good. It
+// matches one of the errors above with errors.Is, and still unwraps to the
+// *tgx.BotError for errors.As.
+type SendError struct {
+	Method string
+	Kind   error
+	Err    error
+}
+
+func (e *SendError) Error() string {
+	return fmt.Sprintf("%s: %v: %v", e.Method, e.Kind, e.Err)
+}
+
+func (e *SendError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// Permanent reports whether sending the same request again later is pointless.
+func (e *SendError) Permanent() bool {
+	return e.Kind != ErrRateLimited && e.Kind != ErrTelegramUnavailable
+}
+
+// sendErrorKind classifies the error of a failed Bot API request.
+func sendErrorKind(err error) error {
+	for _, kind := range []error{ErrChatGone, ErrRateLimited, ErrTelegramUnavailable, ErrRejected} {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+	if isChatGone(err) {
+		return ErrChatGone
+	}
+	var botErr *tgx.BotError
+	switch {
+	case !errors.As(err, &botErr):
+		return ErrRejected
+	case botErr.Code == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case botErr.Code >= http.StatusInternalServerError:
+		return ErrTelegramUnavailable
+	}
+	return ErrRejected
+}
+
+// sendDeadline is the Unix time in nanoseconds after which no more retries
+// are started. Lambda runs one invocation at a time, so a single value is
+// enough.
+var sendDeadline atomic.Int64
+
+// setSendDeadline limits retries to the invocation ending at deadline.
+func setSendDeadline(deadline time.Time) {
+	sendDeadline.Store(deadline.Add(-sendDeadlineMargin).UnixNano())
+}
+
+// timeLeft returns how long retries may still wait.
+func timeLeft() time.Duration {
+	deadline := sendDeadline.Load()
+	if deadline == 0 {
+		return time.Minute
+	}
+	return time.Until(time.Unix(0, deadline))
+}
+
+type retryTransport struct {
+	base http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != "api.telegram.org" {
+		return t.base.RoundTrip(req)
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, written, err := t.roundTripAttempt(req)
+
+		var wait time.Duration
+		switch {
+		case err != nil && written:
+			// Telegram may have carried out the request and only the answer
+			// got lost. Sending it again could deliver a message twice.
+			return nil, fmt.Errorf("%w: %w", ErrTelegramUnavailable, err)
+		case err != nil:
+			err = fmt.Errorf("%w: %w", ErrTelegramUnavailable, err)
+			wait = backoff(attempt)
+		case resp.StatusCode == http.StatusTooManyRequests:
+			wait, resp = retryAfter(resp)
+		case resp.StatusCode >= http.StatusInternalServerError:
+			wait = backoff(attempt)
+		default:
+			return resp, nil
+		}
+
+		if attempt == sendAttempts || wait > timeLeft() || req.GetBody == nil {
+			return resp, err
+		}
+		log.Printf("WARN: Telegram request failed (attempt %d), retrying in %v", attempt, wait)
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+
+		body, bodyErr := req.GetBody()
+		if bodyErr != nil {
+			return nil, bodyErr
+		}
+		req = req.Clone(req.Context())
+		req.Body = body
+	}
+}
+
+// roundTripAttempt sends the request once, giving up after
+// sendAttemptTimeout or when the invocation runs out of time, whichever comes
+// first. The timeout covers reading the body, so it is only released when
+// the body is closed. It also reports whether the request was written to
+// the connection, after which a failure may hide a request that went
+// through.
+func (t *retryTransport) roundTripAttempt(req *http.Request) (*http.Response, bool, error) {
+	// The last attempt still gets sendDeadlineMargin, the time kept free for
+	// answering the user.
+	timeout := max(min(sendAttemptTimeout, timeLeft()), sendDeadlineMargin)
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+
+	var written atomic.Bool
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteHeaders: func() { written.Store(true) },
+	})
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, written.Load(), err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, true, nil
+}
+
+// cancelOnClose releases the timeout of an attempt once its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// retryAfter reads how long Telegram wants the bot to wait from a 429
+// response. The body is read, so the returned response carries a copy of it.
+func retryAfter(resp *http.Response) (time.Duration, *http.Response) {
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return backoff(1), resp
+	}
+
+	var result apiResponse
+	if json.Unmarshal(body, &result) != nil || result.Parameters.RetryAfter == 0 {
+		return backoff(1), resp
+	}
+	return time.Duration(result.Parameters.RetryAfter) * time.Second, resp
+}
+
+// backoff returns an exponential delay with full jitter.
+func backoff(attempt int) time.Duration {
+	ceiling := sendBackoffBase << (attempt - 1)
+	return time.Duration(rand.Int63n(int64(ceiling))) + time.Millisecond
+}
+
+// sendFailureMessage is what the user is told when handling their update
+// failed.
+func sendFailureMessage(err error) string {
+	switch sendErrorKind(err) {
+	case ErrRateLimited, ErrTelegramUnavailable:
+		return MessageTelegramBusy
+	}
+	return MessageErrSomethingWentWrong
+}
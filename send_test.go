@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// telegramTransport sends requests for api.telegram.org to the test server.
+func telegramTransport(server *httptest.Server) *retryTransport {
+	addr := server.Listener.Addr().String()
+	return &retryTransport{base: &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+}
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  func(w http.ResponseWriter, r *http.Request)
+		wantHits int32
+		wantErr  error
+	}{
+		{
+			name: "server error is retried",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, `{"ok":false}`, http.StatusInternalServerError)
+			},
+			wantHits: sendAttempts,
+		},
+		{
+			name: "lost response is not sent again",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+			},
+			wantHits: 1,
+			wantErr:  ErrTelegramUnavailable,
+		},
+		{
+			name: "success",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"ok":true}`))
+			},
+			wantHits: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				hits.Add(1)
+				tt.handler(w, r)
+			}))
+			defer server.Close()
+
+			client := &http.Client{Transport: telegramTransport(server)}
+			resp, err := client.Post("http://api.telegram.org/botTOKEN/sendMessage", "application/json", strings.NewReader(`{"chat_id":1}`))
+			if err == nil {
+				resp.Body.Close()
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if got := hits.Load(); got != tt.wantHits {
+				t.Errorf("Telegram got the request %d times, want %d", got, tt.wantHits)
+			}
+		})
+	}
+}
+
+func TestRetryTransportDialFailure(t *testing.T) {
+	var dials atomic.Int32
+	transport := &retryTransport{base: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dials.Add(1)
+			return nil, errors.New("connection refused")
+		},
+	}}
+
+	client := &http.Client{Transport: transport}
+	_, err := client.Post("http://api.telegram.org/botTOKEN/sendMessage", "application/json", strings.NewReader(`{"chat_id":1}`))
+	if !errors.Is(err, ErrTelegramUnavailable) {
+		t.Errorf("error = %v, want %v", err, ErrTelegramUnavailable)
+	}
+	if got := dials.Load(); got != sendAttempts {
+		t.Errorf("dialed %d times, want %d", got, sendAttempts)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/harshyadavone/anonymous_chat/store"
+	"github.com/harshyadavone/tgx"
+)
+
+// What happens to a user who sends the same opening message to
+// config.SpamPartners partners within config.SpamWindow.
+const (
+	SpamActionReport = "report"
+	SpamActionBan    = "ban"
+)
+
+// fingerprint normalizes text the way the profanity filter does, so case,
+// look-alike characters, punctuation and spacing don't matter, and hashes
+// it. The hash is keyed with the bot token so short texts can't be looked up.
+// It returns "" for texts too short to tell apart from a plain greeting.
+func fingerprint(text string) string {
+	var words []string
+	for _, t := range tokenize(text) {
+		words = append(words, t.word)
+	}
+	normalized := strings.Join(words, " ")
+	if utf8.RuneCountInString(normalized) < config.SpamMinLength {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(botToken))
+	mac.Write([]byte(normalized))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkSpamFingerprint fingerprints the first config.SpamFirstMessages
+// messages a user sends in a session and acts on users who send the same
+// one to many partners. It reports whether the message may still be
+// relayed.
+func checkSpamFingerprint(ctx context.Context, b *tgx.Bot, user *store.User, text string) bool {
+	if config.SpamPartners <= 0 {
+		return true
+	}
+	if user.FingerprintSession != user.SessionId {
+		user.FingerprintSession = user.SessionId
+		user.FingerprintCount = 0
+	}
+	if user.FingerprintCount >= config.SpamFirstMessages {
+		return true
+	}
+	user.FingerprintCount++
+	if err := UpdateUser(ctx, user); err != nil {
+		log.Printf("WARN: Failed to count opening messages of user %d: %v", user.ChatId, err)
+	}
+
+	hash := fingerprint(text)
+	if hash == "" {
+		return true
+	}
+	fp := &store.Fingerprint{SenderChatId: user.ChatId, Hash: hash, SessionId: user.SessionId, PartnerChatId: user.Partner}
+	if err := userStore.SaveFingerprint(ctx, fp, config.SpamWindow); err != nil {
+		log.Printf("WARN: Failed to save fingerprint for user %d: %v", user.ChatId, err)
+		return true
+	}
+	partners, err := userStore.CountFingerprintPartners(ctx, user.ChatId, hash, time.Now().Add(-config.SpamWindow))
+	if err != nil {
+		log.Printf("WARN: Failed to count fingerprints of user %d: %v", user.ChatId, err)
+		return true
+	}
+	if partners < config.SpamPartners {
+		return true
+	}
+
+	log.Printf("FLAG: User %d sent the same opening message to %d partners.", user.ChatId, partners)
+	if config.SpamAction == SpamActionBan {
+		banUser(user, banDuration(user, 0))
+		if err := enforceBan(b, user); err != nil {
+			log.Printf("ERROR: Failed to ban spammer %d: %v", user.ChatId, err)
+		}
+		alertAdmins(fmt.Sprintf(MessageAdminSpam, user.ChatId, partners, config.SpamWindow, "banned until "+banEnd(user)))
+		return false
+	}
+
+	_, err = fileReport(ctx, nil, user, user.SessionId, ReportReasonSpam)
+	if errors.Is(err, store.ErrDuplicateReport) {
+		return true
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to report spammer %d: %v", user.ChatId, err)
+		return true
+	}
+	if err := UpdateUser(ctx, user); err != nil {
+		log.Printf("ERROR: Failed to update report score of spammer %d: %v", user.ChatId, err)
+	}
+	alertAdmins(fmt.Sprintf(MessageAdminSpam, user.ChatId, partners, config.SpamWindow, "reported"))
+	if user.IsBanned(time.Now()) {
+		// The report pushed them over a ban threshold.
+		HandleStop(b, user.ChatId)
+		return false
+	}
+	return true
+}
+
+// alertAdmins sends a notice to every admin chat.
+func alertAdmins(text string) {
+	for _, chatId := range config.AdminChatIds {
+		if err := bot.SendMessage(chatId, text); err != nil {
+			log.Printf("ERROR: Failed to alert admin chat %d: %v", chatId, err)
+		}
+	}
+}
+
+func parseSpamAction(value string) string {
+	switch action := strings.ToLower(value); action {
+	case SpamActionReport, SpamActionBan:
+		return action
+	}
+	log.Printf("WARN: Invalid SPAM_ACTION %q, using %q", value, SpamActionReport)
+	return SpamActionReport
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Fingerprint records that a sender opened a session with a message whose
+// normalized text hashes to Hash. The text itself is never stored, and the
+// record expires on its own after the detection window.
+type Fingerprint struct {
+	SenderChatId   int64  `dynamodbav:"SenderChatId"`
+	FingerprintKey string `dynamodbav:"FingerprintKey"` // "hash:session"
+	Hash           string `dynamodbav:"Hash"`
+	SessionId      string `dynamodbav:"SessionId"`
+	PartnerChatId  int64  `dynamodbav:"PartnerChatId"`
+	CreatedAt      int64  `dynamodbav:"CreatedAt"`
+	ExpiresAt      int64  `dynamodbav:"ExpiresAt"`
+}
+
+// SaveFingerprint stores a fingerprint for ttl. The same hash is only stored
+// once per session.
+func (s *DynamoDBStore) SaveFingerprint(ctx context.Context, fp *Fingerprint, ttl time.Duration) error {
+	now := time.Now()
+	fp.FingerprintKey = fp.Hash + ":" + fp.SessionId
+	fp.CreatedAt = now.Unix()
+	fp.ExpiresAt = now.Add(ttl).Unix()
+
+	item, err := attributevalue.MarshalMap(fp)
+	if err != nil {
+		return fmt.Errorf("failed to marshal fingerprint: %w", err)
+	}
+	_, err = s.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.FingerprintsTableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(FingerprintKey)"),
+	})
+	if err != nil {
+		var conditionErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionErr) {
+			return nil
+		}
+		return fmt.Errorf("failed to put fingerprint: %w", err)
+	}
+	return nil
+}
+
+// CountFingerprintPartners returns how many different partners a sender
+// sent a message with the given hash to since the given time.
+func (s *DynamoDBStore) CountFingerprintPartners(ctx context.Context, senderChatId int64, hash string, since time.Time) (int, error) {
+	partners := make(map[int64]bool)
+	paginator := dynamodb.NewQueryPaginator(s.Client, &dynamodb.QueryInput{
+		TableName:              aws.String(s.FingerprintsTableName),
+		KeyConditionExpression: aws.String("SenderChatId = :sender AND begins_with(FingerprintKey, :hash)"),
+		FilterExpression:       aws.String("CreatedAt >= :since"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":sender": &types.AttributeValueMemberN{Value: strconv.FormatInt(senderChatId, 10)},
+			":hash":   &types.AttributeValueMemberS{Value: hash + ":"},
+			":since":  &types.AttributeValueMemberN{Value: strconv.FormatInt(since.Unix(), 10)},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to query fingerprints: %w", err)
+		}
+		var fingerprints []Fingerprint
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &fingerprints); err != nil {
+			return 0, fmt.Errorf("failed to unmarshal fingerprints: %w", err)
+		}
+		for _, fp := range fingerprints {
+			partners[fp.PartnerChatId] = true
+		}
+	}
+	return len(partners), nil
+}
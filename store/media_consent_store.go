@@ -0,0 +1,221 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Consent answers and held media only matter while the session lasts.
+const mediaConsentTTL = 24 * time.Hour
+
+// MediaConsent is what a user answered about receiving media in one session.
+// It is kept apart from the user record so that the parts of an album, which
+// arrive in separate invocations, can claim the prompt with a conditional
+// write and only one of them asks.
+type MediaConsent struct {
+	SessionId string `dynamodbav:"SessionId"`
+	Key       string `dynamodbav:"Key"` // "consent:<recipient chat ID>"
+	State     string `dynamodbav:"State"`
+	ExpiresAt int64  `dynamodbav:"ExpiresAt"`
+}
+
+// HeldMedia is a media message waiting for the partner's consent, along with
+// its text as the relay filters left it.
+type HeldMedia struct {
+	SessionId string `dynamodbav:"SessionId"`
+	Key       string `dynamodbav:"Key"` // "held:<sender chat ID>:<message ID>"
+	ChatId    int64  `dynamodbav:"ChatId"`
+	MessageId int64  `dynamodbav:"MessageId"`
+	Message   string `dynamodbav:"Message"` // the message as Telegram sent it, in JSON
+	Text      string `dynamodbav:"Text,omitempty"`
+	Modified  bool   `dynamodbav:"Modified,omitempty"`
+	ExpiresAt int64  `dynamodbav:"ExpiresAt"`
+}
+
+func mediaConsentKey(recipient int64) string {
+	return fmt.Sprintf("consent:%d", recipient)
+}
+
+func heldMediaPrefix(sender int64) string {
+	return fmt.Sprintf("held:%d:", sender)
+}
+
+// GetMediaConsent returns what the recipient answered in the session, or ""
+// if they were not asked yet.
+func (s *DynamoDBStore) GetMediaConsent(ctx context.Context, sessionId string, recipient int64) (string, error) {
+	result, err := s.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.MediaConsentTableName),
+		Key: map[string]types.AttributeValue{
+			"SessionId": &types.AttributeValueMemberS{Value: sessionId},
+			"Key":       &types.AttributeValueMemberS{Value: mediaConsentKey(recipient)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get media consent: %w", err)
+	}
+	if result.Item == nil {
+		return "", nil
+	}
+
+	var consent MediaConsent
+	if err := attributevalue.UnmarshalMap(result.Item, &consent); err != nil {
+		return "", fmt.Errorf("failed to unmarshal media consent: %w", err)
+	}
+	return consent.State, nil
+}
+
+// RequestMediaConsent sets the recipient's consent to pending unless they
+// already have one in the session. Only the first caller wins; the others
+// get false and the current state.
+func (s *DynamoDBStore) RequestMediaConsent(ctx context.Context, sessionId string, recipient int64, pending string) (bool, string, error) {
+	item, err := attributevalue.MarshalMap(MediaConsent{
+		SessionId: sessionId,
+		Key:       mediaConsentKey(recipient),
+		State:     pending,
+		ExpiresAt: time.Now().Add(mediaConsentTTL).Unix(),
+	})
+	if err != nil {
+		return false, "", fmt.Errorf("failed to marshal media consent: %w", err)
+	}
+
+	_, err = s.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.MediaConsentTableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(SessionId)"),
+	})
+	if err == nil {
+		return true, pending, nil
+	}
+
+	var conditionErr *types.ConditionalCheckFailedException
+	if !errors.As(err, &conditionErr) {
+		return false, "", fmt.Errorf("failed to request media consent: %w", err)
+	}
+	state, err := s.GetMediaConsent(ctx, sessionId, recipient)
+	return false, state, err
+}
+
+// AnswerMediaConsent moves the recipient's consent from pending to state. It
+// returns false if there was no pending request to answer.
+func (s *DynamoDBStore) AnswerMediaConsent(ctx context.Context, sessionId string, recipient int64, pending, state string) (bool, error) {
+	_, err := s.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.MediaConsentTableName),
+		Key: map[string]types.AttributeValue{
+			"SessionId": &types.AttributeValueMemberS{Value: sessionId},
+			"Key":       &types.AttributeValueMemberS{Value: mediaConsentKey(recipient)},
+		},
+		UpdateExpression:    aws.String("SET #state = :state"),
+		ConditionExpression: aws.String("#state = :pending"),
+		ExpressionAttributeNames: map[string]string{
+			"#state": "State",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":state":   &types.AttributeValueMemberS{Value: state},
+			":pending": &types.AttributeValueMemberS{Value: pending},
+		},
+	})
+	if err != nil {
+		var conditionErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionErr) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to answer media consent: %w", err)
+	}
+	return true, nil
+}
+
+// HoldMedia stores a media message until the partner answers. At most limit
+// messages are held per sender and session; it returns false for the rest.
+func (s *DynamoDBStore) HoldMedia(ctx context.Context, held *HeldMedia, limit int) (bool, error) {
+	count, err := s.Client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.MediaConsentTableName),
+		KeyConditionExpression: aws.String("SessionId = :session AND begins_with(#key, :prefix)"),
+		ExpressionAttributeNames: map[string]string{
+			"#key": "Key",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":session": &types.AttributeValueMemberS{Value: held.SessionId},
+			":prefix":  &types.AttributeValueMemberS{Value: heldMediaPrefix(held.ChatId)},
+		},
+		Select: types.SelectCount,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to count held media: %w", err)
+	}
+	if int(count.Count) >= limit {
+		return false, nil
+	}
+
+	// Zero-padded so the held messages sort in the order they were sent.
+	held.Key = fmt.Sprintf("%s%020d", heldMediaPrefix(held.ChatId), held.MessageId)
+	held.ExpiresAt = time.Now().Add(mediaConsentTTL).Unix()
+	item, err := attributevalue.MarshalMap(held)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal held media: %w", err)
+	}
+	_, err = s.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.MediaConsentTableName),
+		Item:      item,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to put held media: %w", err)
+	}
+	return true, nil
+}
+
+// TakeHeldMedia removes and returns the media the sender has waiting in the
+// session, in the order they were sent. Each message is deleted with a
+// condition, so when two invocations take at the same time every message
+// goes to exactly one of them.
+func (s *DynamoDBStore) TakeHeldMedia(ctx context.Context, sessionId string, sender int64) ([]HeldMedia, error) {
+	result, err := s.Client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.MediaConsentTableName),
+		KeyConditionExpression: aws.String("SessionId = :session AND begins_with(#key, :prefix)"),
+		ExpressionAttributeNames: map[string]string{
+			"#key": "Key",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":session": &types.AttributeValueMemberS{Value: sessionId},
+			":prefix":  &types.AttributeValueMemberS{Value: heldMediaPrefix(sender)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query held media: %w", err)
+	}
+
+	var taken []HeldMedia
+	for _, item := range result.Items {
+		deleted, err := s.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(s.MediaConsentTableName),
+			Key: map[string]types.AttributeValue{
+				"SessionId": item["SessionId"],
+				"Key":       item["Key"],
+			},
+			ConditionExpression: aws.String("attribute_exists(SessionId)"),
+			ReturnValues:        types.ReturnValueAllOld,
+		})
+		if err != nil {
+			var conditionErr *types.ConditionalCheckFailedException
+			if errors.As(err, &conditionErr) {
+				continue
+			}
+			return taken, fmt.Errorf("failed to take held media: %w", err)
+		}
+
+		var held HeldMedia
+		if err := attributevalue.UnmarshalMap(deleted.Attributes, &held); err != nil {
+			return taken, fmt.Errorf("failed to unmarshal held media: %w", err)
+		}
+		taken = append(taken, held)
+	}
+	return taken, nil
+}
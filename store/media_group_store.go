@@ -0,0 +1,168 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Parts of an album are only needed until the album has been sent on.
+const mediaGroupTTL = time.Hour
+
+// The claim marker of an album is stored next to its parts under message ID 0,
+// which Telegram never assigns.
+const mediaGroupClaimId = 0
+
+// MediaGroupPart is one photo, video, document or audio of an album that is
+// waiting to be relayed.
+type MediaGroupPart struct {
+	GroupId   string `dynamodbav:"GroupId"`
+	MessageId int64  `dynamodbav:"MessageId"`
+	ChatId    int64  `dynamodbav:"ChatId"`
+	Type      string `dynamodbav:"Type"`
+	FileId    string `dynamodbav:"FileId"`
+	Caption   string `dynamodbav:"Caption,omitempty"`
+	ExpiresAt int64  `dynamodbav:"ExpiresAt"`
+}
+
+// mediaGroupClaim records which invocation collects an album. Done is set
+// once it stopped waiting for parts, so later parts are relayed on their own.
+type mediaGroupClaim struct {
+	GroupId   string `dynamodbav:"GroupId"`
+	MessageId int64  `dynamodbav:"MessageId"`
+	Done      bool   `dynamodbav:"Done"`
+	ExpiresAt int64  `dynamodbav:"ExpiresAt"`
+}
+
+func (s *DynamoDBStore) SaveMediaGroupPart(ctx context.Context, part *MediaGroupPart) error {
+	part.ExpiresAt = time.Now().Add(mediaGroupTTL).Unix()
+	item, err := attributevalue.MarshalMap(part)
+	if err != nil {
+		return fmt.Errorf("failed to marshal media group part: %w", err)
+	}
+
+	_, err = s.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.MediaGroupsTableName),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put media group part: %w", err)
+	}
+	return nil
+}
+
+// GetMediaGroupParts returns the parts of an album received so far, in the
+// order they were sent.
+func (s *DynamoDBStore) GetMediaGroupParts(ctx context.Context, groupId string) ([]MediaGroupPart, error) {
+	result, err := s.Client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.MediaGroupsTableName),
+		KeyConditionExpression: aws.String("GroupId = :group AND MessageId > :claim"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":group": &types.AttributeValueMemberS{Value: groupId},
+			":claim": &types.AttributeValueMemberN{Value: strconv.Itoa(mediaGroupClaimId)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query media group parts: %w", err)
+	}
+
+	var parts []MediaGroupPart
+	if err := attributevalue.UnmarshalListOfMaps(result.Items, &parts); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal media group parts: %w", err)
+	}
+	return parts, nil
+}
+
+// ClaimMediaGroup makes the caller the one to collect and send an album.
+// Only the first caller wins; the others get false and whether the winner is
+// done collecting, in which case their part was left for them to relay.
+func (s *DynamoDBStore) ClaimMediaGroup(ctx context.Context, groupId string) (bool, bool, error) {
+	item, err := attributevalue.MarshalMap(mediaGroupClaim{
+		GroupId:   groupId,
+		MessageId: mediaGroupClaimId,
+		ExpiresAt: time.Now().Add(mediaGroupTTL).Unix(),
+	})
+	if err != nil {
+		return false, false, fmt.Errorf("failed to marshal media group claim: %w", err)
+	}
+
+	_, err = s.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.MediaGroupsTableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(GroupId)"),
+	})
+	if err == nil {
+		return true, false, nil
+	}
+
+	var conditionErr *types.ConditionalCheckFailedException
+	if !errors.As(err, &conditionErr) {
+		return false, false, fmt.Errorf("failed to claim media group: %w", err)
+	}
+
+	result, err := s.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.MediaGroupsTableName),
+		Key:            mediaGroupKey(groupId, mediaGroupClaimId),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return false, false, fmt.Errorf("failed to get media group claim: %w", err)
+	}
+
+	var claim mediaGroupClaim
+	if err := attributevalue.UnmarshalMap(result.Item, &claim); err != nil {
+		return false, false, fmt.Errorf("failed to unmarshal media group claim: %w", err)
+	}
+	return false, claim.Done, nil
+}
+
+// CloseMediaGroup records that the winner of ClaimMediaGroup stopped waiting
+// for parts. It must be called before the winner takes the parts, so that
+// every part is either taken by the winner or sees the album closed.
+func (s *DynamoDBStore) CloseMediaGroup(ctx context.Context, groupId string) error {
+	_, err := s.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(s.MediaGroupsTableName),
+		Key:              mediaGroupKey(groupId, mediaGroupClaimId),
+		UpdateExpression: aws.String("SET Done = :done"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":done": &types.AttributeValueMemberBOOL{Value: true},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to close media group: %w", err)
+	}
+	return nil
+}
+
+// TakeMediaGroupPart removes a part of an album and reports whether this
+// caller removed it, and so is the one to send it.
+func (s *DynamoDBStore) TakeMediaGroupPart(ctx context.Context, groupId string, messageId int64) (bool, error) {
+	_, err := s.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:           aws.String(s.MediaGroupsTableName),
+		Key:                 mediaGroupKey(groupId, messageId),
+		ConditionExpression: aws.String("attribute_exists(GroupId)"),
+	})
+	if err != nil {
+		var conditionErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionErr) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to take media group part: %w", err)
+	}
+	return true, nil
+}
+
+func mediaGroupKey(groupId string, messageId int64) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"GroupId":   &types.AttributeValueMemberS{Value: groupId},
+		"MessageId": &types.AttributeValueMemberN{Value: strconv.FormatInt(messageId, 10)},
+	}
+}
@@ -0,0 +1,171 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Telegram only lets bots delete messages younger than 48 hours, so there is
+// no point keeping a mapping around for longer than that.
+const relayedMessageTTL = 48 * time.Hour
+
+// RelayedMessage maps a message in one chat of a session to its counterpart in
+// the partner's chat. Every relay is stored twice, once from each side.
+type RelayedMessage struct {
+	SessionId     string `dynamodbav:"SessionId"`
+	MessageKey    string `dynamodbav:"MessageKey"`
+	ChatId        int64  `dynamodbav:"ChatId"`
+	MessageId     int64  `dynamodbav:"MessageId"`
+	PeerChatId    int64  `dynamodbav:"PeerChatId"`
+	PeerMessageId int64  `dynamodbav:"PeerMessageId"`
+	Outgoing      bool   `dynamodbav:"Outgoing"` // true if ChatId's user wrote the message
+	ViewOnce      bool   `dynamodbav:"ViewOnce"`
+	ExpiresAt     int64  `dynamodbav:"ExpiresAt"`
+}
+
+func MessageKey(chatId, messageId int64) string {
+	return fmt.Sprintf("%d:%d", chatId, messageId)
+}
+
+func newSessionId() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate session id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// SaveRelayedMessage records a relayed message given from the sender's side,
+// along with the mirrored record for the partner's copy.
+func (s *DynamoDBStore) SaveRelayedMessage(ctx context.Context, sent *RelayedMessage) error {
+	sent.MessageKey = MessageKey(sent.ChatId, sent.MessageId)
+	sent.Outgoing = true
+	sent.ExpiresAt = time.Now().Add(relayedMessageTTL).Unix()
+
+	received := *sent
+	received.MessageKey = MessageKey(sent.PeerChatId, sent.PeerMessageId)
+	received.ChatId, received.PeerChatId = sent.PeerChatId, sent.ChatId
+	received.MessageId, received.PeerMessageId = sent.PeerMessageId, sent.MessageId
+	received.Outgoing = false
+
+	records := []RelayedMessage{*sent, received}
+
+	var writes []types.WriteRequest
+	for _, record := range records {
+		item, err := attributevalue.MarshalMap(record)
+		if err != nil {
+			return fmt.Errorf("failed to marshal relayed message: %w", err)
+		}
+		writes = append(writes, types.WriteRequest{PutRequest: &types.PutRequest{Item: item}})
+	}
+
+	_, err := s.Client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+		RequestItems: map[string][]types.WriteRequest{s.MessagesTableName: writes},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write relayed message: %w", err)
+	}
+	return nil
+}
+
+// GetRelayedMessage looks up a message of the session by the chat it is in.
+// It returns nil if the message was not relayed in this session.
+func (s *DynamoDBStore) GetRelayedMessage(ctx context.Context, sessionId string, chatId, messageId int64) (*RelayedMessage, error) {
+	result, err := s.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.MessagesTableName),
+		Key: map[string]types.AttributeValue{
+			"SessionId":  &types.AttributeValueMemberS{Value: sessionId},
+			"MessageKey": &types.AttributeValueMemberS{Value: MessageKey(chatId, messageId)},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get relayed message: %w", err)
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var record RelayedMessage
+	if err := attributevalue.UnmarshalMap(result.Item, &record); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal relayed message: %w", err)
+	}
+	return &record, nil
+}
+
+// GetSessionMessages returns every relayed message record of a session.
+func (s *DynamoDBStore) GetSessionMessages(ctx context.Context, sessionId string) ([]RelayedMessage, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(s.MessagesTableName),
+		KeyConditionExpression: aws.String("SessionId = :session"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":session": &types.AttributeValueMemberS{Value: sessionId},
+		},
+	}
+
+	var records []RelayedMessage
+	paginator := dynamodb.NewQueryPaginator(s.Client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query session messages: %w", err)
+		}
+		var batch []RelayedMessage
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &batch); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal session messages: %w", err)
+		}
+		records = append(records, batch...)
+	}
+	return records, nil
+}
+
+// GetViewOnceMessages returns the view-once copies the partner sent into
+// chatId during the session that are still visible.
+func (s *DynamoDBStore) GetViewOnceMessages(ctx context.Context, sessionId string, chatId int64) ([]RelayedMessage, error) {
+	result, err := s.Client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.MessagesTableName),
+		KeyConditionExpression: aws.String("SessionId = :session AND begins_with(MessageKey, :chat)"),
+		FilterExpression:       aws.String("ViewOnce = :true AND Outgoing = :false"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":session": &types.AttributeValueMemberS{Value: sessionId},
+			":chat":    &types.AttributeValueMemberS{Value: fmt.Sprintf("%d:", chatId)},
+			":true":    &types.AttributeValueMemberBOOL{Value: true},
+			":false":   &types.AttributeValueMemberBOOL{Value: false},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query view-once messages: %w", err)
+	}
+
+	var records []RelayedMessage
+	if err := attributevalue.UnmarshalListOfMaps(result.Items, &records); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal view-once messages: %w", err)
+	}
+	return records, nil
+}
+
+// ClearViewOnce marks a view-once copy as removed from the chat.
+func (s *DynamoDBStore) ClearViewOnce(ctx context.Context, record *RelayedMessage) error {
+	_, err := s.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.MessagesTableName),
+		Key: map[string]types.AttributeValue{
+			"SessionId":  &types.AttributeValueMemberS{Value: record.SessionId},
+			"MessageKey": &types.AttributeValueMemberS{Value: record.MessageKey},
+		},
+		UpdateExpression: aws.String("SET ViewOnce = :false"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":false": &types.AttributeValueMemberBOOL{Value: false},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to clear view-once flag: %w", err)
+	}
+	return nil
+}
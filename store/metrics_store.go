@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const metricDayLayout = "2006-01-02"
+
+// IncrementMetric adds one to a daily counter. Counters are kept per metric,
+// day and label, and never say anything about who caused them.
+func (s *DynamoDBStore) IncrementMetric(ctx context.Context, name, label string) error {
+	key := time.Now().UTC().Format(metricDayLayout) + ":" + label
+	_, err := s.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.MetricsTableName),
+		Key: map[string]types.AttributeValue{
+			"Metric":   &types.AttributeValueMemberS{Value: name},
+			"DayLabel": &types.AttributeValueMemberS{Value: key},
+		},
+		UpdateExpression: aws.String("ADD #count :one"),
+		ExpressionAttributeNames: map[string]string{
+			"#count": "Count",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one": &types.AttributeValueMemberN{Value: "1"},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to increment metric %s: %w", name, err)
+	}
+	return nil
+}
+
+// GetMetric returns the counts of a metric per label over the given number
+// of days, today included.
+func (s *DynamoDBStore) GetMetric(ctx context.Context, name string, days int) (map[string]int64, error) {
+	since := time.Now().UTC().AddDate(0, 0, 1-days).Format(metricDayLayout)
+	counts := make(map[string]int64)
+
+	paginator := dynamodb.NewQueryPaginator(s.Client, &dynamodb.QueryInput{
+		TableName:              aws.String(s.MetricsTableName),
+		KeyConditionExpression: aws.String("Metric = :metric AND DayLabel >= :since"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":metric": &types.AttributeValueMemberS{Value: name},
+			":since":  &types.AttributeValueMemberS{Value: since},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query metric %s: %w", name, err)
+		}
+		for _, item := range page.Items {
+			key, ok := item["DayLabel"].(*types.AttributeValueMemberS)
+			if !ok || len(key.Value) <= len(metricDayLayout) {
+				continue
+			}
+			counts[key.Value[len(metricDayLayout)+1:]] += number(item["Count"])
+		}
+	}
+	return counts, nil
+}
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// A bucket that has not been touched for this long is full again anyway.
+const rateBucketTTL = time.Hour
+
+// Concurrent invocations for the same user race on the bucket; the loser
+// re-reads it and tries again this many times.
+const rateBucketAttempts = 3
+
+// RateLimit describes a token bucket: Burst messages at once, refilled at
+// PerMinute messages a minute.
+type RateLimit struct {
+	Burst     float64
+	PerMinute float64
+}
+
+type rateBucket struct {
+	ChatId    int64   `dynamodbav:"ChatId"`
+	Bucket    string  `dynamodbav:"Bucket"`
+	Tokens    float64 `dynamodbav:"Tokens"`
+	UpdatedAt int64   `dynamodbav:"UpdatedAt"` // unix milliseconds
+	Notified  bool    `dynamodbav:"Notified"`
+	ExpiresAt int64   `dynamodbav:"ExpiresAt"`
+}
+
+// TokenResult is the outcome of taking a token from a bucket.
+type TokenResult struct {
+	Allowed bool
+	// Notify is set the first time a message is refused since the last one
+	// that got through, so the sender is told only once.
+	Notify     bool
+	RetryAfter time.Duration
+}
+
+// TakeToken takes a token from the named bucket of a user if one is left.
+func (s *DynamoDBStore) TakeToken(ctx context.Context, chatId int64, bucket string, limit RateLimit) (TokenResult, error) {
+	for attempt := 0; attempt < rateBucketAttempts; attempt++ {
+		result, err := s.tryTakeToken(ctx, chatId, bucket, limit)
+		var conditionErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionErr) {
+			continue
+		}
+		return result, err
+	}
+	return TokenResult{}, fmt.Errorf("rate bucket %s of user %d is contended", bucket, chatId)
+}
+
+func (s *DynamoDBStore) tryTakeToken(ctx context.Context, chatId int64, bucket string, limit RateLimit) (TokenResult, error) {
+	key := map[string]types.AttributeValue{
+		"ChatId": &types.AttributeValueMemberN{Value: strconv.FormatInt(chatId, 10)},
+		"Bucket": &types.AttributeValueMemberS{Value: bucket},
+	}
+
+	result, err := s.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.RateLimitsTableName),
+		Key:            key,
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return TokenResult{}, fmt.Errorf("failed to get rate bucket: %w", err)
+	}
+
+	now := time.Now()
+	state := rateBucket{ChatId: chatId, Bucket: bucket, Tokens: limit.Burst}
+	condition := "attribute_not_exists(ChatId)"
+	values := map[string]types.AttributeValue{}
+	if result.Item != nil {
+		if err := attributevalue.UnmarshalMap(result.Item, &state); err != nil {
+			return TokenResult{}, fmt.Errorf("failed to unmarshal rate bucket: %w", err)
+		}
+		condition = "UpdatedAt = :updated"
+		values[":updated"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(state.UpdatedAt, 10)}
+
+		elapsed := now.Sub(time.UnixMilli(state.UpdatedAt)).Minutes()
+		state.Tokens = math.Min(limit.Burst, state.Tokens+elapsed*limit.PerMinute)
+	}
+
+	var outcome TokenResult
+	if state.Tokens >= 1 {
+		state.Tokens--
+		state.Notified = false
+		outcome.Allowed = true
+	} else {
+		outcome.Notify = !state.Notified
+		state.Notified = true
+		if limit.PerMinute > 0 {
+			outcome.RetryAfter = time.Duration((1 - state.Tokens) / limit.PerMinute * float64(time.Minute))
+		}
+	}
+	state.UpdatedAt = now.UnixMilli()
+	state.ExpiresAt = now.Add(rateBucketTTL).Unix()
+
+	item, err := attributevalue.MarshalMap(state)
+	if err != nil {
+		return TokenResult{}, fmt.Errorf("failed to marshal rate bucket: %w", err)
+	}
+
+	input := &dynamodb.PutItemInput{
+		TableName:           aws.String(s.RateLimitsTableName),
+		Item:                item,
+		ConditionExpression: aws.String(condition),
+	}
+	if len(values) > 0 {
+		input.ExpressionAttributeValues = values
+	}
+	if _, err := s.Client.PutItem(ctx, input); err != nil {
+		var conditionErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionErr) {
+			return TokenResult{}, err
+		}
+		return TokenResult{}, fmt.Errorf("failed to put rate bucket: %w", err)
+	}
+	return outcome, nil
+}
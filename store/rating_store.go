@@ -0,0 +1,133 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrAlreadyRated is returned when a user rates a session they already rated.
+var ErrAlreadyRated = errors.New("session already rated")
+
+// ErrRatingExpired is returned when a user rates a session after the rating
+// window closed, or one they were never asked to rate.
+var ErrRatingExpired = errors.New("rating expired")
+
+// Ratings only need to outlive the prompt. Once given they are added to the
+// rated user's RatingsUp or RatingsDown.
+const ratingTTL = 7 * 24 * time.Hour
+
+// Rating is what one participant of a session thought of the other. Value is
+// 1 for a thumbs up, -1 for a thumbs down and 0 while the rating is pending.
+type Rating struct {
+	SessionId   string `dynamodbav:"SessionId"`
+	RaterChatId int64  `dynamodbav:"RaterChatId"`
+	RatedChatId int64  `dynamodbav:"RatedChatId"`
+	Value       int    `dynamodbav:"Value"`
+	CreatedAt   int64  `dynamodbav:"CreatedAt"`
+	RatedAt     int64  `dynamodbav:"RatedAt,omitempty"`
+	ExpiresAt   int64  `dynamodbav:"ExpiresAt"`
+}
+
+// CreateRatings stores a pending rating for each side of a session, so the
+// participants can rate each other without knowing who the other was.
+func (s *DynamoDBStore) CreateRatings(ctx context.Context, sessionId string, a, b int64) error {
+	now := time.Now()
+	created, expires := now.Unix(), now.Add(ratingTTL).Unix()
+	ratings := []Rating{
+		{SessionId: sessionId, RaterChatId: a, RatedChatId: b, CreatedAt: created, ExpiresAt: expires},
+		{SessionId: sessionId, RaterChatId: b, RatedChatId: a, CreatedAt: created, ExpiresAt: expires},
+	}
+
+	var writes []types.WriteRequest
+	for _, rating := range ratings {
+		item, err := attributevalue.MarshalMap(rating)
+		if err != nil {
+			return fmt.Errorf("failed to marshal rating: %w", err)
+		}
+		writes = append(writes, types.WriteRequest{PutRequest: &types.PutRequest{Item: item}})
+	}
+
+	_, err := s.Client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+		RequestItems: map[string][]types.WriteRequest{s.RatingsTableName: writes},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write ratings: %w", err)
+	}
+	return nil
+}
+
+// RateSession records the rating a participant gave and returns it. Each
+// participant can rate a session once, and only until the rating expires.
+// TTL deletes expired ratings only eventually, so the expiry is checked here.
+func (s *DynamoDBStore) RateSession(ctx context.Context, sessionId string, raterChatId int64, value int) (*Rating, error) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	result, err := s.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.RatingsTableName),
+		Key: map[string]types.AttributeValue{
+			"SessionId":   &types.AttributeValueMemberS{Value: sessionId},
+			"RaterChatId": &types.AttributeValueMemberN{Value: strconv.FormatInt(raterChatId, 10)},
+		},
+		UpdateExpression:    aws.String("SET #value = :value, RatedAt = :at"),
+		ConditionExpression: aws.String("#value = :pending AND ExpiresAt > :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#value": "Value",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":value":   &types.AttributeValueMemberN{Value: strconv.Itoa(value)},
+			":pending": &types.AttributeValueMemberN{Value: "0"},
+			":at":      &types.AttributeValueMemberN{Value: now},
+			":now":     &types.AttributeValueMemberN{Value: now},
+		},
+		ReturnValues:                        types.ReturnValueAllNew,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	if err != nil {
+		var conditionErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionErr) {
+			if number(conditionErr.Item["Value"]) != 0 {
+				return nil, ErrAlreadyRated
+			}
+			return nil, ErrRatingExpired
+		}
+		return nil, fmt.Errorf("failed to update rating: %w", err)
+	}
+
+	var rating Rating
+	if err := attributevalue.UnmarshalMap(result.Attributes, &rating); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal rating: %w", err)
+	}
+	return &rating, nil
+}
+
+// GetRecentRatings returns up to limit ratings a user was asked to give,
+// newest first. Every session ended with /stop in the last week left one, so
+// it doubles as the list of a user's recent sessions.
+func (s *DynamoDBStore) GetRecentRatings(ctx context.Context, raterChatId int64, limit int32) ([]Rating, error) {
+	result, err := s.Client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.RatingsTableName),
+		IndexName:              aws.String("RaterIndex"),
+		KeyConditionExpression: aws.String("RaterChatId = :rater"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":rater": &types.AttributeValueMemberN{Value: strconv.FormatInt(raterChatId, 10)},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int32(limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ratings by user %d: %w", raterChatId, err)
+	}
+
+	var ratings []Rating
+	if err := attributevalue.UnmarshalListOfMaps(result.Items, &ratings); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal ratings: %w", err)
+	}
+	return ratings, nil
+}
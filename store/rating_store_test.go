@@ -0,0 +1,106 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// fakeRatings answers UpdateItem for a single rating the way DynamoDB would
+// for the condition RateSession sends. TTL never deletes anything here,
+// like DynamoDB in the hours before it gets to an expired item.
+type fakeRatings struct {
+	value     int64
+	expiresAt int64
+}
+
+type attributeValue struct {
+	N string `json:"N,omitempty"`
+	S string `json:"S,omitempty"`
+}
+
+func (f *fakeRatings) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ConditionExpression       string
+		ExpressionAttributeValues map[string]attributeValue
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	arg := func(name string) int64 {
+		n, _ := strconv.ParseInt(req.ExpressionAttributeValues[name].N, 10, 64)
+		return n
+	}
+
+	ok := f.value == arg(":pending")
+	if strings.Contains(req.ConditionExpression, "ExpiresAt > :now") {
+		ok = ok && f.expiresAt > arg(":now")
+	}
+	item := map[string]attributeValue{
+		"SessionId":   {S: "session"},
+		"RaterChatId": {N: "1"},
+		"RatedChatId": {N: "2"},
+		"Value":       {N: strconv.FormatInt(f.value, 10)},
+		"ExpiresAt":   {N: strconv.FormatInt(f.expiresAt, 10)},
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]any{
+			"__type":  "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException",
+			"message": "The conditional request failed",
+			"Item":    item,
+		})
+		return
+	}
+	f.value = arg(":value")
+	item["Value"] = attributeValue{N: strconv.FormatInt(f.value, 10)}
+	json.NewEncoder(w).Encode(map[string]any{"Attributes": item})
+}
+
+func TestRateSession(t *testing.T) {
+	now := time.Now().Unix()
+	tests := []struct {
+		name    string
+		rating  fakeRatings
+		wantErr error
+	}{
+		{"pending", fakeRatings{value: 0, expiresAt: now + 3600}, nil},
+		{"already rated", fakeRatings{value: 1, expiresAt: now + 3600}, ErrAlreadyRated},
+		{"expired but not deleted yet", fakeRatings{value: 0, expiresAt: now - 3600}, ErrRatingExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(&tt.rating)
+			defer server.Close()
+
+			s := &DynamoDBStore{
+				Client: dynamodb.New(dynamodb.Options{
+					BaseEndpoint: aws.String(server.URL),
+					Region:       "us-east-1",
+					Credentials:  aws.AnonymousCredentials{},
+					Retryer:      aws.NopRetryer{},
+				}),
+				RatingsTableName: "ratings",
+			}
+			rating, err := s.RateSession(context.Background(), "session", 1, 1)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RateSession() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (rating.Value != 1 || rating.RatedChatId != 2) {
+				t.Errorf("RateSession() = %+v, want value 1 for user 2", rating)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Report statuses. Reports are pending until a moderator upholds or dismisses
+// them.
+const (
+	ReportPending   = "pending"
+	ReportUpheld    = "upheld"
+	ReportDismissed = "dismissed"
+)
+
+var (
+	// ErrDuplicateReport is returned when a reporter already reported the
+	// user in the same session.
+	ErrDuplicateReport = errors.New("user already reported in this session")
+	// ErrReportDecided is returned when a moderator decides on a report that
+	// is no longer pending.
+	ErrReportDecided = errors.New("report already decided")
+)
+
+// Report is a complaint about a user, stored under the reported user.
+// Reports filed by the bot itself have a ReporterChatId of 0.
+type Report struct {
+	ReportedChatId int64  `dynamodbav:"ReportedChatId"`
+	ReportKey      string `dynamodbav:"ReportKey"`
+	ReporterChatId int64  `dynamodbav:"ReporterChatId"`
+	SessionId      string `dynamodbav:"SessionId"`
+	Reason         string `dynamodbav:"Reason"`
+	CreatedAt      int64  `dynamodbav:"CreatedAt"` // unix seconds
+	Status         string `dynamodbav:"Status"`
+	// Weight is how much the report counts towards the reported user's
+	// score, from the reporter's credibility when it was filed.
+	Weight float64 `dynamodbav:"Weight"`
+	// DecidedBy is the moderator who upheld or dismissed the report.
+	DecidedBy int64 `dynamodbav:"DecidedBy,omitempty"`
+	DecidedAt int64 `dynamodbav:"DecidedAt,omitempty"`
+
+	// Evidence holds copies of the last messages the reported user sent in
+	// the session, kept in EvidenceChatId for moderators.
+	EvidenceChatId     int64   `dynamodbav:"EvidenceChatId,omitempty"`
+	EvidenceMessageIds []int64 `dynamodbav:"EvidenceMessageIds,omitempty"`
+}
+
+// ReportKey identifies a report among those against the same user. The bot
+// files reports as reporter 0 for several reasons, so its keys carry the
+// reason too and a profanity report doesn't stop a spam report in the same
+// session.
+func ReportKey(sessionId string, reporterChatId int64, reason string) string {
+	if reporterChatId == 0 {
+		return fmt.Sprintf("%s:0:%s", sessionId, reason)
+	}
+	return fmt.Sprintf("%s:%d", sessionId, reporterChatId)
+}
+
+// SaveReport stores a new report. A reporter can only report a user once per
+// session, later reports fail with ErrDuplicateReport.
+func (s *DynamoDBStore) SaveReport(ctx context.Context, report *Report) error {
+	report.ReportKey = ReportKey(report.SessionId, report.ReporterChatId, report.Reason)
+	if report.CreatedAt == 0 {
+		report.CreatedAt = time.Now().Unix()
+	}
+	if report.Status == "" {
+		report.Status = ReportPending
+	}
+
+	item, err := attributevalue.MarshalMap(report)
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %w", err)
+	}
+
+	_, err = s.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.ReportsTableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(ReportKey)"),
+	})
+	if err != nil {
+		var conditionErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionErr) {
+			return ErrDuplicateReport
+		}
+		return fmt.Errorf("failed to put report: %w", err)
+	}
+	return nil
+}
+
+// GetReport returns the report a reporter filed against a user in a session,
+// or nil if there is none. The reason only tells apart reports by the bot.
+func (s *DynamoDBStore) GetReport(ctx context.Context, reportedChatId int64, sessionId string, reporterChatId int64, reason string) (*Report, error) {
+	result, err := s.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.ReportsTableName),
+		Key: map[string]types.AttributeValue{
+			"ReportedChatId": &types.AttributeValueMemberN{Value: strconv.FormatInt(reportedChatId, 10)},
+			"ReportKey":      &types.AttributeValueMemberS{Value: ReportKey(sessionId, reporterChatId, reason)},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get report: %w", err)
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var report Report
+	if err := attributevalue.UnmarshalMap(result.Item, &report); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal report: %w", err)
+	}
+	return &report, nil
+}
+
+// GetReports returns every report against a user.
+func (s *DynamoDBStore) GetReports(ctx context.Context, reportedChatId int64) ([]Report, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(s.ReportsTableName),
+		KeyConditionExpression: aws.String("ReportedChatId = :reported"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":reported": &types.AttributeValueMemberN{Value: strconv.FormatInt(reportedChatId, 10)},
+		},
+	}
+
+	var reports []Report
+	paginator := dynamodb.NewQueryPaginator(s.Client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query reports: %w", err)
+		}
+		var batch []Report
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &batch); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal reports: %w", err)
+		}
+		reports = append(reports, batch...)
+	}
+	return reports, nil
+}
+
+// GetPendingReports returns up to limit reports waiting for a moderator,
+// oldest first.
+func (s *DynamoDBStore) GetPendingReports(ctx context.Context, limit int32) ([]Report, error) {
+	result, err := s.Client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.ReportsTableName),
+		IndexName:              aws.String("StatusIndex"),
+		KeyConditionExpression: aws.String("#status = :pending"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "Status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: ReportPending},
+		},
+		ScanIndexForward: aws.Bool(true),
+		Limit:            aws.Int32(limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pending reports: %w", err)
+	}
+
+	var reports []Report
+	if err := attributevalue.UnmarshalListOfMaps(result.Items, &reports); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal pending reports: %w", err)
+	}
+	return reports, nil
+}
+
+// DecideReport records a moderator's decision on a pending report and
+// returns the updated report. Deciding a report twice fails with
+// ErrReportDecided.
+func (s *DynamoDBStore) DecideReport(ctx context.Context, reportedChatId int64, reportKey, status string, decidedBy int64) (*Report, error) {
+	result, err := s.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.ReportsTableName),
+		Key: map[string]types.AttributeValue{
+			"ReportedChatId": &types.AttributeValueMemberN{Value: strconv.FormatInt(reportedChatId, 10)},
+			"ReportKey":      &types.AttributeValueMemberS{Value: reportKey},
+		},
+		UpdateExpression:    aws.String("SET #status = :status, DecidedBy = :by, DecidedAt = :at"),
+		ConditionExpression: aws.String("#status = :pending"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "Status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":status":  &types.AttributeValueMemberS{Value: status},
+			":pending": &types.AttributeValueMemberS{Value: ReportPending},
+			":by":      &types.AttributeValueMemberN{Value: strconv.FormatInt(decidedBy, 10)},
+			":at":      &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+	if err != nil {
+		var conditionErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionErr) {
+			return nil, ErrReportDecided
+		}
+		return nil, fmt.Errorf("failed to update report: %w", err)
+	}
+
+	var report Report
+	if err := attributevalue.UnmarshalMap(result.Attributes, &report); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal report: %w", err)
+	}
+	return &report, nil
+}
@@ -0,0 +1,26 @@
+package store
+
+import "testing"
+
+func TestReportKey(t *testing.T) {
+	const session = "0123456789abcdef"
+
+	// The bot first reports profanity, then spam, in the same session.
+	profanity := ReportKey(session, 0, "profanity")
+	spam := ReportKey(session, 0, "spam")
+	if profanity == spam {
+		t.Errorf("bot reports for profanity and spam share the key %q", spam)
+	}
+	if again := ReportKey(session, 0, "spam"); again != spam {
+		t.Errorf("a second spam report got key %q, want the duplicate %q", again, spam)
+	}
+
+	// A user reports a partner at most once per session, whatever the reason.
+	harassment := ReportKey(session, 42, "harassment")
+	if other := ReportKey(session, 42, "other"); other != harassment {
+		t.Errorf("reports by the same user got keys %q and %q, want one", harassment, other)
+	}
+	if harassment == profanity || harassment == spam {
+		t.Errorf("user report key %q collides with a bot report", harassment)
+	}
+}
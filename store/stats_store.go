@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Stats is an overview of the bot for moderators. Users is the item count
+// DynamoDB keeps for the users table, which it refreshes every few hours.
+type Stats struct {
+	Users          int64
+	Queued         int
+	PendingReports int
+}
+
+// GetStats counts users, waiting users and pending reports without scanning
+// any table. Chats and bans are kept as daily metrics instead, see
+// IncrementMetric.
+func (s *DynamoDBStore) GetStats(ctx context.Context) (*Stats, error) {
+	var stats Stats
+
+	table, err := s.Client.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(s.TableName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe users table: %w", err)
+	}
+	stats.Users = aws.ToInt64(table.Table.ItemCount)
+
+	for _, queue := range []int{QueueMain, QueueShadow, QueueMinor} {
+		paginator := dynamodb.NewQueryPaginator(s.Client, &dynamodb.QueryInput{
+			TableName:              aws.String(s.TableName),
+			IndexName:              aws.String("IsConnectingIndex"),
+			KeyConditionExpression: aws.String("IsConnecting = :queue"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":queue": &types.AttributeValueMemberN{Value: strconv.Itoa(queue)},
+			},
+			Select: types.SelectCount,
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to count queue %d: %w", queue, err)
+			}
+			stats.Queued += int(page.Count)
+		}
+	}
+
+	result, err := s.Client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.ReportsTableName),
+		IndexName:              aws.String("StatusIndex"),
+		KeyConditionExpression: aws.String("#status = :pending"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "Status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: ReportPending},
+		},
+		Select: types.SelectCount,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count pending reports: %w", err)
+	}
+	stats.PendingReports = int(result.Count)
+
+	return &stats, nil
+}
+
+func number(value types.AttributeValue) int64 {
+	n, ok := value.(*types.AttributeValueMemberN)
+	if !ok {
+		return 0
+	}
+	i, _ := strconv.ParseInt(n.Value, 10, 64)
+	return i
+}
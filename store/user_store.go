@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -14,21 +17,181 @@ import (
 )
 
 type User struct {
-	ChatId        int64  `dynamodbav:"ChatId"`
+	ChatId int64 `dynamodbav:"ChatId"`
+	// FirstSeenAt (unix seconds) is when the user was first saved.
+	FirstSeenAt int64 `dynamodbav:"FirstSeenAt"`
+	// Grandfathered is set on users who were saved before FirstSeenAt and
+	// SessionCount were kept, whose history is therefore unknown.
+	Grandfathered bool `dynamodbav:"Grandfathered,omitempty"`
+	// IsConnecting is the queue the user waits in for a partner, QueueMain,
+	// QueueShadow or QueueMinor, or 0 when they are not waiting.
 	IsConnecting  int    `dynamodbav:"IsConnecting"`
 	IsConnected   bool   `dynamodbav:"IsConnected"`
 	Partner       int64  `dynamodbav:"Partner,omitempty"`
-	ReportCount   int    `dynamodbav:"ReportCount"`
 	Gender        string `dynamodbav:"Gender,omitempty"`
 	PartnerGender string `dynamodbav:"PartnerGender,omitempty"`
+	SessionId     string `dynamodbav:"SessionId,omitempty"`
+	WipeOnEnd     bool   `dynamodbav:"WipeOnEnd"`
+	SessionCount  int    `dynamodbav:"SessionCount"`
+
+	// ProtectContent stops the partner from forwarding or saving anything the
+	// user sends. ViewOnce also hides their photos and videos behind a spoiler
+	// and removes them once the partner answers or the chat ends.
+	ProtectContent bool `dynamodbav:"ProtectContent"`
+	ViewOnce       bool `dynamodbav:"ViewOnce"`
+	// ViewOncePending is set while the user's chat holds view-once media from
+	// their partner that still has to be removed.
+	ViewOncePending bool `dynamodbav:"ViewOncePending"`
+	// FlagCount is the number of the user's messages the relay filters
+	// flagged or blocked.
+	FlagCount int `dynamodbav:"FlagCount"`
+	// PIICount is the number of messages in which the user shared contact
+	// details such as a phone number or username.
+	PIICount int `dynamodbav:"PIICount"`
+	// ProfanityCount is the number of messages that matched the word lists.
+	ProfanityCount int `dynamodbav:"ProfanityCount"`
+	// HeldMessage is the message waiting for the user to confirm it, as the
+	// JSON Telegram sent it.
+	HeldMessage string `dynamodbav:"HeldMessage,omitempty"`
+	// ReportScore is the weighted score of the reports against the user as of
+	// ReportScoreAt (unix seconds). It decays over time, see reports.go.
+	ReportScore   float64 `dynamodbav:"ReportScore"`
+	ReportScoreAt int64   `dynamodbav:"ReportScoreAt"`
+	// LegacyReportCount is the plain report count kept before report scores.
+	// GetUser turns it into a score, and it is dropped on the next write.
+	LegacyReportCount int `dynamodbav:"ReportCount,omitempty"`
+	// ReportsUpheld and ReportsDismissed count the moderator decisions on
+	// reports the user filed, which make up their credibility as a reporter.
+	ReportsUpheld    int `dynamodbav:"ReportsUpheld"`
+	ReportsDismissed int `dynamodbav:"ReportsDismissed"`
+	// BannedUntil (unix seconds) keeps the user out of matching while it is
+	// in the future. BanCount counts the bans the user has had.
+	BannedUntil  int64 `dynamodbav:"BannedUntil,omitempty"`
+	BanPermanent bool  `dynamodbav:"BanPermanent"`
+	BanCount     int   `dynamodbav:"BanCount"`
+	// RatingsUp and RatingsDown count the 👍 and 👎 partners gave the user
+	// after their chats.
+	RatingsUp   int `dynamodbav:"RatingsUp"`
+	RatingsDown int `dynamodbav:"RatingsDown"`
+	// ShadowUntil (unix seconds) keeps a user with a bad reputation in the
+	// shadow pool, where they are only matched with each other.
+	ShadowUntil int64 `dynamodbav:"ShadowUntil,omitempty"`
+	// AppealStatus is the state of the user's latest ban appeal: pending,
+	// accepted or rejected.
+	AppealStatus    string `dynamodbav:"AppealStatus,omitempty"`
+	AppealText      string `dynamodbav:"AppealText,omitempty"`
+	AppealedAt      int64  `dynamodbav:"AppealedAt,omitempty"`
+	AppealDecidedBy int64  `dynamodbav:"AppealDecidedBy,omitempty"`
+	// RulesVersion is the version of the community rules the user accepted,
+	// 0 if they never did. Minor is set for users who said they are under 18,
+	// who are only matched with each other.
+	RulesVersion int  `dynamodbav:"RulesVersion"`
+	Minor        bool `dynamodbav:"Minor"`
+	// ChallengePassed is set once a new user proved they are not a bot.
+	// ChallengeAnswer is the picture they were last asked to pick in
+	// challenge ChallengeId, and
+	// ChallengeBlockedUntil (unix seconds) stops them from trying again after
+	// too many ChallengeFailures.
+	ChallengePassed       bool   `dynamodbav:"ChallengePassed"`
+	ChallengeAnswer       string `dynamodbav:"ChallengeAnswer,omitempty"`
+	ChallengeId           string `dynamodbav:"ChallengeId,omitempty"`
+	ChallengeFailures     int    `dynamodbav:"ChallengeFailures"`
+	ChallengeBlockedUntil int64  `dynamodbav:"ChallengeBlockedUntil,omitempty"`
+	// FingerprintCount is how many messages of session FingerprintSession
+	// were fingerprinted to catch users who paste the same opener everywhere.
+	FingerprintSession string `dynamodbav:"FingerprintSession,omitempty"`
+	FingerprintCount   int    `dynamodbav:"FingerprintCount"`
+	// MediaPolicy is whether the user is asked before receiving media (ask or
+	// empty), always receives it (allow) or never does (block). What they
+	// answered when asked is kept per session, see media_consent_store.go.
+	MediaPolicy string `dynamodbav:"MediaPolicy,omitempty"`
+	// CrisisNoticeSession is the session in which the user was last sent
+	// helpline resources, so they get them once per session.
+	CrisisNoticeSession string `dynamodbav:"CrisisNoticeSession,omitempty"`
+	// RevealSession is the session in which the user agreed to swap Telegram
+	// accounts with their partner, and RevealDeclinedSession the one in which
+	// they turned that down.
+	RevealSession         string `dynamodbav:"RevealSession,omitempty"`
+	RevealDeclinedSession string `dynamodbav:"RevealDeclinedSession,omitempty"`
+	// RevealedSession is the session in which the accounts were swapped.
+	RevealedSession string `dynamodbav:"RevealedSession,omitempty"`
+	// Inactive is set when Telegram reports the user blocked the bot or their
+	// chat is gone. Inactive users are never matched until they come back.
+	Inactive bool `dynamodbav:"Inactive"`
+}
+
+// Queues users wait in for a partner. Users are only matched with others in
+// the same queue.
+const (
+	QueueMain   = 1
+	QueueShadow = 2
+	QueueMinor  = 3
+)
+
+// RatingScore is the positive reputation of the user from the ratings of
+// their partners, between 0 and 1. Users without ratings score 0.5.
+func (u *User) RatingScore() float64 {
+	up := float64(u.RatingsUp)
+	return (up + 1) / (up + float64(u.RatingsDown) + 2)
+}
+
+// Queue returns the queue the user belongs in at the given time. Minors are
+// kept apart from adults even when they are in the shadow pool.
+func (u *User) Queue(now time.Time) int {
+	if u.Minor {
+		return QueueMinor
+	}
+	if u.ShadowUntil > now.Unix() {
+		return QueueShadow
+	}
+	return QueueMain
+}
+
+// Appeal statuses.
+const (
+	AppealPending  = "pending"
+	AppealAccepted = "accepted"
+	AppealRejected = "rejected"
+)
+
+// ErrUserNotFound is returned when a user was never saved.
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrBanned is returned when a banned user tries to find a partner.
+var ErrBanned = errors.New("user is banned")
+
+// IsBanned reports whether the user is banned at the given time.
+func (u *User) IsBanned(now time.Time) bool {
+	return u.BanPermanent || u.BannedUntil > now.Unix()
+}
+
+// Tables names the DynamoDB tables the store works with.
+type Tables struct {
+	Users        string
+	Messages     string
+	MediaGroups  string
+	RateLimits   string
+	Reports      string
+	Ratings      string
+	Fingerprints string
+	Metrics      string
+	MediaConsent string
 }
 
 type DynamoDBStore struct {
-	Client    *dynamodb.Client
-	TableName string
+	Client                *dynamodb.Client
+	TableName             string
+	MessagesTableName     string
+	MediaGroupsTableName  string
+	RateLimitsTableName   string
+	ReportsTableName      string
+	RatingsTableName      string
+	FingerprintsTableName string
+	MetricsTableName      string
+	MediaConsentTableName string
 }
 
-func New(ctx context.Context, tableName string) (*DynamoDBStore, error) {
+func New(ctx context.Context, tables Tables) (*DynamoDBStore, error) {
 	customResolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
 		if os.Getenv("AWS_SAM_LOCAL") == "true" {
 			return aws.Endpoint{
@@ -46,7 +209,18 @@ func New(ctx context.Context, tableName string) (*DynamoDBStore, error) {
 	}
 
 	client := dynamodb.NewFromConfig(cfg)
-	return &DynamoDBStore{Client: client, TableName: tableName}, nil
+	return &DynamoDBStore{
+		Client:                client,
+		TableName:             tables.Users,
+		MessagesTableName:     tables.Messages,
+		MediaGroupsTableName:  tables.MediaGroups,
+		RateLimitsTableName:   tables.RateLimits,
+		ReportsTableName:      tables.Reports,
+		RatingsTableName:      tables.Ratings,
+		FingerprintsTableName: tables.Fingerprints,
+		MetricsTableName:      tables.Metrics,
+		MediaConsentTableName: tables.MediaConsent,
+	}, nil
 }
 
 func (s *DynamoDBStore) GetUser(ctx context.Context, chatId int64) (*User, error) {
@@ -65,7 +239,7 @@ func (s *DynamoDBStore) GetUser(ctx context.Context, chatId int64) (*User, error
 		return nil, fmt.Errorf("failed to get item from DynamoDB: %w", err)
 	}
 	if result.Item == nil {
-		return nil, errors.New("user not found")
+		return nil, ErrUserNotFound
 	}
 
 	var user User
@@ -73,10 +247,22 @@ func (s *DynamoDBStore) GetUser(ctx context.Context, chatId int64) (*User, error
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal DynamoDB item: %w", err)
 	}
+	if user.LegacyReportCount > 0 && user.ReportScore == 0 && user.ReportScoreAt == 0 {
+		// Each old report counts as one of full weight, decaying from now.
+		user.ReportScore = float64(user.LegacyReportCount)
+		user.ReportScoreAt = time.Now().Unix()
+	}
+	user.LegacyReportCount = 0
+	if user.FirstSeenAt == 0 {
+		user.Grandfathered = true
+	}
 	return &user, nil
 }
 
 func (s *DynamoDBStore) UpdateUser(ctx context.Context, user *User) error {
+	if user.FirstSeenAt == 0 {
+		user.FirstSeenAt = time.Now().Unix()
+	}
 	item, err := attributevalue.MarshalMap(user)
 	if err != nil {
 		return fmt.Errorf("failed to marshal user into DynamoDB item: %w", err)
@@ -93,6 +279,13 @@ func (s *DynamoDBStore) UpdateUser(ctx context.Context, user *User) error {
 }
 
 func (s *DynamoDBStore) FindAndConnectPartner(ctx context.Context, me *User) (*User, *User, error) {
+	now := time.Now()
+	if me.IsBanned(now) {
+		return nil, nil, ErrBanned
+	}
+
+	queue := me.Queue(now)
+
 	var queryInput *dynamodb.QueryInput
 
 	// Determine which index to query based on user's preference
@@ -103,7 +296,7 @@ func (s *DynamoDBStore) FindAndConnectPartner(ctx context.Context, me *User) (*U
 			IndexName:              aws.String("IsConnecting_GenderIndex"),
 			KeyConditionExpression: aws.String("IsConnecting = :connecting AND Gender = :gender"),
 			ExpressionAttributeValues: map[string]types.AttributeValue{
-				":connecting": &types.AttributeValueMemberN{Value: "1"},
+				":connecting": &types.AttributeValueMemberN{Value: strconv.Itoa(queue)},
 				":gender":     &types.AttributeValueMemberS{Value: me.PartnerGender},
 			},
 			Limit: aws.Int32(100),
@@ -115,7 +308,7 @@ func (s *DynamoDBStore) FindAndConnectPartner(ctx context.Context, me *User) (*U
 			IndexName:              aws.String("IsConnectingIndex"),
 			KeyConditionExpression: aws.String("IsConnecting = :connecting"),
 			ExpressionAttributeValues: map[string]types.AttributeValue{
-				":connecting": &types.AttributeValueMemberN{Value: "1"},
+				":connecting": &types.AttributeValueMemberN{Value: strconv.Itoa(queue)},
 			},
 			Limit: aws.Int32(100),
 		}
@@ -130,6 +323,8 @@ func (s *DynamoDBStore) FindAndConnectPartner(ctx context.Context, me *User) (*U
 		return nil, nil, nil
 	}
 
+	// Of everyone who fits, pick the partner rated most like me, so users
+	// who are rated well get matched with each other.
 	var partner *User
 	for _, item := range result.Items {
 		var p User
@@ -139,7 +334,9 @@ func (s *DynamoDBStore) FindAndConnectPartner(ctx context.Context, me *User) (*U
 			continue
 		}
 
-		if p.ChatId == me.ChatId {
+		// Someone who was moved to the shadow pool while waiting is skipped
+		// until they queue again.
+		if p.ChatId == me.ChatId || p.Inactive || p.IsBanned(now) || p.Queue(now) != queue {
 			continue
 		}
 
@@ -148,9 +345,11 @@ func (s *DynamoDBStore) FindAndConnectPartner(ctx context.Context, me *User) (*U
 		// Their preference matches my gender
 		partnerPrefersMe := p.PartnerGender == "" || p.PartnerGender == "any" || p.PartnerGender == me.Gender
 
-		if mePrefersPartner && partnerPrefersMe {
+		if !mePrefersPartner || !partnerPrefersMe {
+			continue
+		}
+		if partner == nil || math.Abs(p.RatingScore()-me.RatingScore()) < math.Abs(partner.RatingScore()-me.RatingScore()) {
 			partner = &p
-			break // Found a match
 		}
 	}
 
@@ -158,13 +357,22 @@ func (s *DynamoDBStore) FindAndConnectPartner(ctx context.Context, me *User) (*U
 		return nil, nil, nil
 	}
 
+	sessionId, err := newSessionId()
+	if err != nil {
+		return nil, nil, err
+	}
+
 	me.IsConnected = true
 	me.IsConnecting = 0
 	me.Partner = partner.ChatId
+	me.SessionId = sessionId
+	me.SessionCount++
 
 	partner.IsConnected = true
 	partner.IsConnecting = 0
 	partner.Partner = me.ChatId
+	partner.SessionId = sessionId
+	partner.SessionCount++
 
 	mePut, err := s.createPut(me)
 	if err != nil {
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/harshyadavone/tgx"
+)
+
+// tgx does not return the result of send calls and has no wrappers for a few
+// methods the bot needs, so those requests go straight to the Bot API here.
+// Failures are returned as *SendError, which still unwraps to the tgx error
+// types. Timeouts are applied per attempt by retryTransport, so the client
+// itself has none.
+
+var apiClient = &http.Client{}
+
+type apiResponse struct {
+	Ok          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	Description string          `json:"description"`
+	ErrorCode   int             `json:"error_code"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after,omitempty"`
+	} `json:"parameters"`
+}
+
+// isChatGone reports whether a send failed because the receiving user blocked
+// the bot, deleted their account or the chat no longer exists. Retrying such
+// a send can never succeed.
+func isChatGone(err error) bool {
+	var botErr *tgx.BotError
+	if !errors.As(err, &botErr) {
+		return false
+	}
+	apiErr, ok := botErr.Err.(*tgx.APIError)
+	if !ok {
+		return false
+	}
+	switch {
+	case apiErr.Code == http.StatusForbidden:
+		// "bot was blocked by the user", "user is deactivated"
+		return true
+	case apiErr.Code == http.StatusBadRequest && strings.Contains(apiErr.Description, "chat not found"):
+		return true
+	}
+	return false
+}
+
+// sentMessage is the part of a sent Message the bot cares about.
+type sentMessage struct {
+	MessageId int64 `json:"message_id"`
+}
+
+func callAPI(method string, params map[string]interface{}) (json.RawMessage, error) {
+	result, err := doCallAPI(method, params)
+	if err != nil {
+		return nil, &SendError{Method: method, Kind: sendErrorKind(err), Err: err}
+	}
+	return result, nil
+}
+
+func doCallAPI(method string, params map[string]interface{}) (json.RawMessage, error) {
+	url := fmt.Sprintf("https://api.telegram.org/bot%s/%s", botToken, method)
+
+	body, err := json.Marshal(params)
+	if err != nil {
+		return nil, &tgx.BotError{Code: http.StatusInternalServerError, Message: "failed to encode request parameters", Err: err}
+	}
+
+	resp, err := apiClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, &tgx.BotError{Code: http.StatusServiceUnavailable, Message: "failed to send request", Err: err}
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &tgx.BotError{Code: http.StatusServiceUnavailable, Message: "failed to read response body", Err: err}
+	}
+
+	var result apiResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, &tgx.BotError{Code: http.StatusInternalServerError, Message: "failed to parse response", Err: err}
+	}
+
+	if !result.Ok {
+		apiErr := &tgx.APIError{Code: result.ErrorCode, Description: result.Description}
+		apiErr.Parameters.RetryAfter = result.Parameters.RetryAfter
+		return nil, &tgx.BotError{Code: result.ErrorCode, Message: fmt.Sprintf("%s failed", method), Err: apiErr}
+	}
+
+	return result.Result, nil
+}
+
+// relayOptions are the delivery settings applied to everything relayed
+// from a user.
+type relayOptions struct {
+	ProtectContent bool
+	Spoiler        bool
+	// Caption replaces the caption of a copied message when set.
+	Caption *string
+}
+
+// sendForId calls a method that returns a single Message and returns its ID.
+func sendForId(method string, params map[string]interface{}) (int64, error) {
+	result, err := callAPI(method, params)
+	if err != nil {
+		return 0, err
+	}
+
+	var sent sentMessage
+	if err := json.Unmarshal(result, &sent); err != nil {
+		return 0, fmt.Errorf("failed to decode %s result: %w", method, err)
+	}
+	return sent.MessageId, nil
+}
+
+// copyMessage relays a message into another chat and returns the ID of the copy.
+func copyMessage(chatId, fromChatId, messageId int64, opts relayOptions) (int64, error) {
+	params := map[string]interface{}{
+		"chat_id":      chatId,
+		"from_chat_id": fromChatId,
+		"message_id":   messageId,
+	}
+	if opts.ProtectContent {
+		params["protect_content"] = true
+	}
+	if opts.Caption != nil {
+		params["caption"] = *opts.Caption
+	}
+	return sendForId("copyMessage", params)
+}
+
+func sendText(chatId int64, text string, opts relayOptions) (int64, error) {
+	params := map[string]interface{}{
+		"chat_id": chatId,
+		"text":    text,
+	}
+	if opts.ProtectContent {
+		params["protect_content"] = true
+	}
+	return sendForId("sendMessage", params)
+}
+
+// sendMedia sends a photo or video by file ID. Unlike copyMessage it can hide
+// the media behind a spoiler.
+func sendMedia(chatId int64, mediaType, fileId, caption string, opts relayOptions) (int64, error) {
+	params := map[string]interface{}{
+		"chat_id":     chatId,
+		mediaType:     fileId,
+		"caption":     caption,
+		"has_spoiler": opts.Spoiler,
+	}
+	if opts.ProtectContent {
+		params["protect_content"] = true
+	}
+	switch mediaType {
+	case "photo":
+		return sendForId("sendPhoto", params)
+	case "video":
+		return sendForId("sendVideo", params)
+	default:
+		return 0, fmt.Errorf("unsupported media type %q", mediaType)
+	}
+}
+
+// sendMediaGroup sends an album by file ID and returns the IDs of the sent
+// messages in order.
+func sendMediaGroup(chatId int64, media []tgx.InputMedia, opts relayOptions) ([]int64, error) {
+	params := map[string]interface{}{
+		"chat_id": chatId,
+		"media":   media,
+	}
+	if opts.ProtectContent {
+		params["protect_content"] = true
+	}
+
+	result, err := callAPI("sendMediaGroup", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var sent []sentMessage
+	if err := json.Unmarshal(result, &sent); err != nil {
+		return nil, fmt.Errorf("failed to decode sendMediaGroup result: %w", err)
+	}
+
+	ids := make([]int64, len(sent))
+	for i, msg := range sent {
+		ids[i] = msg.MessageId
+	}
+	return ids, nil
+}
+
+func deleteMessage(chatId, messageId int64) error {
+	_, err := callAPI("deleteMessage", map[string]interface{}{
+		"chat_id":    chatId,
+		"message_id": messageId,
+	})
+	return err
+}
+
+func setMessageReaction(chatId, messageId int64, reaction []ReactionType) error {
+	_, err := callAPI("setMessageReaction", map[string]interface{}{
+		"chat_id":    chatId,
+		"message_id": messageId,
+		"reaction":   reaction,
+	})
+	return err
+}
+
+func editMessageText(chatId, messageId int64, text string) error {
+	_, err := callAPI("editMessageText", map[string]interface{}{
+		"chat_id":    chatId,
+		"message_id": messageId,
+		"text":       text,
+	})
+	return err
+}
+
+func answerCallbackQuery(queryId, text string, showAlert bool) error {
+	params := map[string]interface{}{
+		"callback_query_id": queryId,
+	}
+	if text != "" {
+		params["text"] = text
+		params["show_alert"] = showAlert
+	}
+	_, err := callAPI("answerCallbackQuery", params)
+	return err
+}
+
+// ChatInfo is what getChat tells about a private chat.
+type ChatInfo struct {
+	Id        int64  `json:"id"`
+	Username  string `json:"username"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+func getChat(chatId int64) (*ChatInfo, error) {
+	result, err := callAPI("getChat", map[string]interface{}{
+		"chat_id": chatId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var chat ChatInfo
+	if err := json.Unmarshal(result, &chat); err != nil {
+		return nil, fmt.Errorf("failed to decode getChat result: %w", err)
+	}
+	return &chat, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/harshyadavone/anonymous_chat/store"
+	"github.com/harshyadavone/tgx"
+)
+
+// Trust levels, and what each of them unlocks. Everyone can send text.
+const (
+	TrustNew = iota
+	TrustMember
+	TrustTrusted
+	TrustVeteran
+)
+
+const (
+	trustMediaLevel  = TrustMember
+	trustLinksLevel  = TrustTrusted
+	trustRevealLevel = TrustVeteran
+)
+
+var trustLevelNames = []string{"New", "Member", "Trusted", "Veteran"}
+
+// trustLevel works out a user's level from how long ago they first used the
+// bot and how many chats they had, then takes a level off for every point of
+// report score and for a poor rating from enough partners. Users from before
+// trust levels start at Member, so they keep the media they could always
+// send.
+func trustLevel(user *store.User, now time.Time) int {
+	age := now.Sub(time.Unix(user.FirstSeenAt, 0))
+	if user.FirstSeenAt == 0 {
+		age = 0
+	}
+
+	level := TrustNew
+	for i := 0; i < len(config.TrustSessions) && i < len(config.TrustAgeDays) && level < TrustVeteran; i++ {
+		days := time.Duration(config.TrustAgeDays[i]) * 24 * time.Hour
+		if user.SessionCount < config.TrustSessions[i] || age < days {
+			break
+		}
+		level++
+	}
+	if user.Grandfathered {
+		level = max(level, TrustMember)
+	}
+
+	level -= int(reportScore(user))
+	rated := user.RatingsUp+user.RatingsDown >= config.TrustMinRatings
+	if rated && user.RatingScore() < config.TrustMinRating {
+		level--
+	}
+	return max(level, TrustNew)
+}
+
+// checkTrust drops messages the sender's trust level doesn't allow yet and
+// tells them when they unlock. It reports whether the message may be
+// relayed.
+func checkTrust(b *tgx.Bot, m *OutgoingMessage) bool {
+	level := trustLevel(m.Sender, time.Now())
+	needed := TrustNew
+	switch {
+	case m.Kind != "Text" && level < trustMediaLevel:
+		needed = trustMediaLevel
+	case hasLink(m.Text, m.Entities) && level < trustLinksLevel:
+		needed = trustLinksLevel
+	default:
+		return true
+	}
+
+	log.Printf("LOG: Message %d from %d needs trust level %d, they are at %d.", m.Msg.MessageId, m.Sender.ChatId, needed, level)
+	what := "photos, videos and stickers"
+	if needed == trustLinksLevel {
+		what = "links"
+	}
+	b.SendMessage(m.Sender.ChatId, fmt.Sprintf(MessageTrustTooLow, what, trustLevelNames[needed]))
+	return false
+}
+
+// HandleMyLevel explains the user's trust level, what it lets them do and
+// what it takes to reach the next one.
+func HandleMyLevel(ctx *tgx.Context) error {
+	user, err := GetUser(context.Background(), ctx.ChatID)
+	if err != nil {
+		user = &store.User{ChatId: ctx.ChatID}
+	}
+	level := trustLevel(user, time.Now())
+
+	unlocked := []string{"text"}
+	if level >= trustMediaLevel {
+		unlocked = append(unlocked, "photos, videos and stickers")
+	}
+	if level >= trustLinksLevel {
+		unlocked = append(unlocked, "links")
+	}
+	if level >= trustRevealLevel {
+		unlocked = append(unlocked, "/reveal")
+	}
+
+	text := fmt.Sprintf(MessageMyLevel, trustLevelNames[level], level, TrustVeteran, strings.Join(unlocked, ", "))
+	if level < TrustVeteran && level < len(config.TrustSessions) && level < len(config.TrustAgeDays) {
+		text += "\n\n" + fmt.Sprintf(MessageNextLevel, trustLevelNames[level+1], config.TrustSessions[level], config.TrustAgeDays[level])
+	}
+	return ctx.Reply(text + "\n\n" + MessageLevelPenalties)
+}
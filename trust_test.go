@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/harshyadavone/anonymous_chat/store"
+)
+
+func TestTrustLevel(t *testing.T) {
+	defer func(saved Config) { config = saved }(config)
+	config.TrustSessions = []int{3, 10, 30}
+	config.TrustAgeDays = []int{0, 2, 7}
+	config.TrustMinRating = 0.4
+	config.TrustMinRatings = 5
+	// Without decay the report score stays exactly what the test sets.
+	config.ReportHalfLife = 0
+
+	now := time.Now()
+	daysAgo := func(days int) int64 { return now.AddDate(0, 0, -days).Unix() }
+	tests := []struct {
+		name string
+		user store.User
+		want int
+	}{
+		{"brand new", store.User{}, TrustNew},
+		{"first chats", store.User{FirstSeenAt: daysAgo(0), SessionCount: 2}, TrustNew},
+		{"member", store.User{FirstSeenAt: daysAgo(0), SessionCount: 3}, TrustMember},
+		{"many chats but too recent", store.User{FirstSeenAt: daysAgo(1), SessionCount: 50}, TrustMember},
+		{"trusted", store.User{FirstSeenAt: daysAgo(2), SessionCount: 10}, TrustTrusted},
+		{"old but few chats", store.User{FirstSeenAt: daysAgo(60), SessionCount: 9}, TrustMember},
+		{"veteran", store.User{FirstSeenAt: daysAgo(7), SessionCount: 30}, TrustVeteran},
+		{"no level above veteran", store.User{FirstSeenAt: daysAgo(365), SessionCount: 1000}, TrustVeteran},
+		{"unknown first use", store.User{SessionCount: 50}, TrustMember},
+		{"grandfathered", store.User{Grandfathered: true}, TrustMember},
+		{"grandfathered and earned more", store.User{Grandfathered: true, FirstSeenAt: daysAgo(7), SessionCount: 30}, TrustVeteran},
+		{"grandfathered with a report", store.User{Grandfathered: true, ReportScore: 1, ReportScoreAt: now.Unix()}, TrustNew},
+		{"veteran with a report", store.User{FirstSeenAt: daysAgo(7), SessionCount: 30, ReportScore: 1, ReportScoreAt: now.Unix()}, TrustTrusted},
+		{"partial report points round down", store.User{FirstSeenAt: daysAgo(7), SessionCount: 30, ReportScore: 2.5, ReportScoreAt: now.Unix()}, TrustMember},
+		{"never below new", store.User{FirstSeenAt: daysAgo(0), SessionCount: 3, ReportScore: 5, ReportScoreAt: now.Unix()}, TrustNew},
+		{"poor rating", store.User{FirstSeenAt: daysAgo(7), SessionCount: 30, RatingsUp: 1, RatingsDown: 4}, TrustTrusted},
+		{"poor rating from too few partners", store.User{FirstSeenAt: daysAgo(7), SessionCount: 30, RatingsDown: 1}, TrustVeteran},
+		{"good rating", store.User{FirstSeenAt: daysAgo(7), SessionCount: 30, RatingsUp: 4, RatingsDown: 1}, TrustVeteran},
+		{"poor rating and a report", store.User{FirstSeenAt: daysAgo(7), SessionCount: 30, RatingsDown: 5, ReportScore: 1, ReportScoreAt: now.Unix()}, TrustMember},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := trustLevel(&tt.user, now); got != tt.want {
+				t.Errorf("trustLevel() = %s, want %s", trustLevelNames[got], trustLevelNames[tt.want])
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/harshyadavone/tgx"
+	"github.com/harshyadavone/tgx/models"
+)
+
+// Update holds the parts of a Telegram update that tgx does not decode or
+// does not pass on to its handlers. Anything handled here never reaches tgx.
+type Update struct {
+	Message         *Message                `json:"message"`
+	MessageReaction *MessageReactionUpdated `json:"message_reaction"`
+	MyChatMember    *ChatMemberUpdated      `json:"my_chat_member"`
+	CallbackQuery   *CallbackQuery          `json:"callback_query"`
+}
+
+type Message struct {
+	models.Message
+	ReplyToMessage  *Message        `json:"reply_to_message"`
+	MediaGroupId    string          `json:"media_group_id"`
+	Entities        []MessageEntity `json:"entities"`
+	CaptionEntities []MessageEntity `json:"caption_entities"`
+	Contact         *Contact        `json:"contact,omitempty"`
+	Location        *Location       `json:"location,omitempty"`
+	Venue           *Venue          `json:"venue,omitempty"`
+}
+
+// Contact, Location and Venue only carry what the filters look at; the
+// message itself is relayed as a copy.
+type Contact struct {
+	PhoneNumber string `json:"phone_number"`
+	FirstName   string `json:"first_name"`
+	UserId      int64  `json:"user_id,omitempty"`
+}
+
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+type Venue struct {
+	Location Location `json:"location"`
+	Title    string   `json:"title"`
+	Address  string   `json:"address"`
+}
+
+type MessageEntity struct {
+	Type   string `json:"type"` // mention, url, text_link, email, phone_number, ...
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+	URL    string `json:"url,omitempty"`
+}
+
+// MessageReactionUpdated is sent when a user changes their reaction to a
+// message. It is only delivered if "message_reaction" is in allowed_updates.
+type MessageReactionUpdated struct {
+	Chat        models.Chat    `json:"chat"`
+	MessageId   int64          `json:"message_id"`
+	User        *models.User   `json:"user"`
+	OldReaction []ReactionType `json:"old_reaction"`
+	NewReaction []ReactionType `json:"new_reaction"`
+}
+
+type ReactionType struct {
+	Type          string `json:"type"` // emoji, custom_emoji or paid
+	Emoji         string `json:"emoji,omitempty"`
+	CustomEmojiId string `json:"custom_emoji_id,omitempty"`
+}
+
+// CallbackQuery is a press of an inline keyboard button. tgx only routes
+// callbacks by their exact data, so callbacks that carry arguments are
+// handled here instead.
+type CallbackQuery struct {
+	Id      string      `json:"id"`
+	From    models.User `json:"from"`
+	Message *Message    `json:"message"`
+	Data    string      `json:"data"`
+}
+
+// ChatMemberUpdated is sent when the status of the bot in a chat changes. In
+// a private chat the bot becomes "kicked" when the user blocks it and
+// "member" again when they unblock it.
+type ChatMemberUpdated struct {
+	Chat          models.Chat `json:"chat"`
+	From          models.User `json:"from"`
+	Date          int64       `json:"date"`
+	OldChatMember ChatMember  `json:"old_chat_member"`
+	NewChatMember ChatMember  `json:"new_chat_member"`
+}
+
+type ChatMember struct {
+	Status string `json:"status"` // creator, administrator, member, restricted, left or kicked
+}
+
+type rawCommandHandler func(b *tgx.Bot, msg *Message) error
+
+// rawCommands are commands that need fields of the message tgx drops, such
+// as the message being replied to.
+var rawCommands = make(map[string]rawCommandHandler)
+
+func onRawCommand(command string, handler rawCommandHandler) {
+	rawCommands[command] = handler
+}
+
+type rawCallbackHandler func(b *tgx.Bot, query *CallbackQuery) error
+
+// rawCallbacks are callbacks by the prefix of their data.
+var rawCallbacks = make(map[string]rawCallbackHandler)
+
+func onRawCallback(prefix string, handler rawCallbackHandler) {
+	rawCallbacks[prefix] = handler
+}
+
+// commandName returns the command in a message text without the leading
+// slash and any @botname suffix, or "" if the text is not a command.
+func commandName(text string) string {
+	if !strings.HasPrefix(text, "/") {
+		return ""
+	}
+	name := strings.Fields(text)[0][1:]
+	if at := strings.Index(name, "@"); at >= 0 {
+		name = name[:at]
+	}
+	return name
+}
+
+// dispatchUpdate handles the update if it is one the bot processes itself and
+// reports whether it did. Other updates are left for tgx.
+func dispatchUpdate(b *tgx.Bot, body []byte) bool {
+	var update Update
+	if err := json.Unmarshal(body, &update); err != nil {
+		log.Printf("WARN: Could not decode update for dispatch: %v", err)
+		return false
+	}
+
+	if update.MessageReaction != nil {
+		if err := HandleReaction(update.MessageReaction); err != nil {
+			log.Printf("ERROR: Failed to relay reaction in chat %d: %v", update.MessageReaction.Chat.Id, err)
+		}
+		return true
+	}
+
+	if update.MyChatMember != nil {
+		if err := HandleMyChatMember(b, update.MyChatMember); err != nil {
+			log.Printf("ERROR: Failed to handle chat member update in chat %d: %v", update.MyChatMember.Chat.Id, err)
+		}
+		return true
+	}
+
+	if query := update.CallbackQuery; query != nil {
+		for prefix, handler := range rawCallbacks {
+			if !strings.HasPrefix(query.Data, prefix) {
+				continue
+			}
+			if err := handler(b, query); err != nil {
+				log.Printf("ERROR: An error occurred in callback %q: %v", query.Data, err)
+				answerCallbackQuery(query.Id, sendFailureMessage(err), true)
+			}
+			return true
+		}
+		return false
+	}
+
+	msg := update.Message
+	if msg == nil {
+		return false
+	}
+
+	var err error
+	if command := commandName(msg.Text); command != "" {
+		handler, ok := rawCommands[command]
+		if !ok {
+			return false
+		}
+		err = handler(b, msg)
+	} else if msg.Chat.Type == "private" {
+		// Messages in groups, such as an admin chat, are never relayed.
+		err = HandleRelay(b, msg)
+	}
+
+	if err != nil {
+		log.Printf("ERROR: An error occurred in an update: %v", err)
+		b.SendMessage(msg.Chat.Id, sendFailureMessage(err))
+	}
+	return true
+}
@@ -14,6 +14,14 @@ const (
 /report - Report your current chat partner.
 /mygender - Set your gender (e.g., /mygender female).
 /partnergender - Set your preferred partner gender (e.g., /partnergender male).
+/unsend - Reply to one of your messages to delete it from your partner's chat.
+/autowipe - Delete the whole conversation from both chats when the chat ends.
+/protect - Stop your partner from forwarding or saving what you send.
+/viewonce - Hide your photos and videos and remove them once your partner answers.
+/reveal - Offer to swap Telegram accounts with your partner. Both of you have to agree.
+/mylevel - See your trust level and what it lets you send.
+/media - Choose whether to be asked before receiving photos and videos (e.g., /media block).
+/appeal - Ask the moderators to lift your ban (e.g., /appeal I was reported by mistake).
 
 Be respectful and stay anonymous! 🤝
 
@@ -34,19 +42,160 @@ Feel free to reach out anytime via @harsh_693.`
 	MessageCurrentlyChatting  = "✅ You are currently chatting with someone. Say hi! 👋"
 	MessageInWaitingList      = "⌛ You are in the waiting list. I'm searching for a partner for you. Hang tight!"
 
+	MessageTelegramBusy          = "⏳ Telegram is busy right now and your last action didn't go through. Please try again in a few seconds."
 	MessageErrSomethingWentWrong = "⚠️ Oops! Something went wrong on my end. Please try again in a moment. If the issue persists, contact support."
 
+	MessageReportReason      = "🚩 Why are you reporting this user?"
+	MessageReportCancelled   = "Report cancelled."
+	MessageBanned            = "🚫 You have been banned from chatting. The ban ends on %s."
+	MessageBannedPermanently = "🚫 You have been permanently banned from chatting."
+	MessageUnbanned          = "✅ Your ban has been lifted. Use /connect to find a partner."
+	MessageBannedAppeal      = "If you think this is a mistake, you can ask the moderators to lift it with /appeal followed by a short explanation."
+
+	MessageAppealNotBanned      = "You are not banned, so there is nothing to appeal."
+	MessageAppealUsage          = "Tell the moderators why your ban should be lifted, e.g. /appeal I was reported by mistake. Keep it under %d characters."
+	MessageAppealPending        = "⌛ Your appeal is waiting for a moderator. You'll hear back once it has been reviewed."
+	MessageAppealTooSoon        = "⌛ Your last appeal was rejected. You can appeal again on %s."
+	MessageAppealSent           = "📨 Your appeal has been sent to the moderators. You'll hear back once it has been reviewed."
+	MessageAppealAccepted       = "✅ Your appeal was accepted and your ban has been lifted. Use /connect to find a partner."
+	MessageAppealRejected       = "❌ Your appeal was rejected. Your ban stays in place."
+	MessageAlreadyReported      = "You have already reported this user for this chat."
+	MessageEvidenceHeader       = "🚩 Report against %d by %d, reason: %s. Their last messages follow."
 	MessageReportConfirmation   = "Thank you for your report. The user has been reported, and your chat has been disconnected."
 	MessageNotInChat            = "You can't perform this action because you are not in a chat. Use /connect to find a partner."
 	MessagePartnerReportWarning = "⚠️ Be advised: This user has been reported multiple times for their behavior. Please be cautious."
 
+	MessageWelcome          = "👋 Welcome! Chat anonymously with random people here. Type /connect to start or /help for commands!"
+	MessageRulesUpdated     = "📜 Our community rules have changed. Please read them and accept them again to keep chatting."
+	MessageRulesAccepted    = "✅ Thanks for accepting the rules."
+	MessageRulesMinor       = "✅ Thanks. As you are under 18, you will only be matched with other users under 18."
+	MessageAskGender        = "Please select your gender:"
+	MessageAskPartnerGender = "Please select your preferred partner gender:"
+	MessageRules            = `📜 Community rules:
+1. Be respectful. No harassment, hate speech or threats.
+2. No sexual content involving minors, ever.
+3. No spam, advertising or scams.
+4. Don't share anyone's personal details, including your own.
+5. Report anyone who breaks these rules with /report.
+
+Breaking the rules gets you banned. Please confirm your age and that you agree.`
+
+	MessageChallenge        = "🤖 Quick check before your first chat: tap the %s."
+	MessageChallengePassed  = "✅ Thanks! Looking for a partner now."
+	MessageChallengeFailed  = "❌ That's not it. Let's try again."
+	MessageChallengeExpired = "This check has expired. Type /connect to try again."
+	MessageChallengeBlocked = "⏳ Too many wrong answers. You can try again after %s."
+
 	MessageGenderSet            = "Your gender has been set to: %s."
 	MessagePartnerGenderSet     = "Your preferred partner gender has been set to: %s."
 	MessageInvalidGender        = "Invalid gender. Please use one of: male, female, other."
 	MessageInvalidPartnerGender = "Invalid preference. Please use one of: male, female, any."
 
+	MessageUnsendUsage     = "↩️ Reply to one of your own messages with /unsend to delete it from your partner's chat."
+	MessageUnsendNotFound  = "⚠️ That message can't be unsent. Only your own messages from the current chat can be unsent."
+	MessageUnsendFailed    = "⚠️ I couldn't delete that message. Messages older than 48 hours can't be unsent."
+	MessageUnsent          = "🗑️ Your message was deleted from your partner's chat."
+	MessageAutoWipeOn      = "🧹 Auto-wipe is on. When a chat ends, the whole conversation will be deleted from both chats."
+	MessageAutoWipeOff     = "Auto-wipe is off. Conversations will stay in your chat history."
+	MessageInvalidAutoWipe = "Invalid option. Use /autowipe on or /autowipe off."
+	MessageProtectOn       = "🔒 Content protection is on. Your partner can't forward or save anything you send."
+	MessageProtectOff      = "🔓 Content protection is off."
+	MessageInvalidProtect  = "Invalid option. Use /protect on or /protect off."
+	MessageViewOnceOn      = "👁️ View-once is on. Your photos and videos are hidden behind a spoiler, can't be saved, and are removed once your partner answers or the chat ends."
+	MessageViewOnceOff     = "View-once is off."
+	MessageInvalidViewOnce = "Invalid option. Use /viewonce on or /viewonce off."
+
+	MessageLinkBlocked        = "🚫 Links can't be sent in chats. Your message was not delivered."
+	MessageTooLong            = "✂️ Your message is too long and was not delivered. Keep it under %d characters."
+	MessageContentTypeBlocked = "🚫 This type of message can't be sent in chats."
+
+	MessagePIIWarning           = "🕵️ Careful! Sharing phone numbers, usernames, emails or links gives away who you are and is a common way scammers find victims."
+	MessagePIIMasked            = "🕵️ Your message contained contact details, so they were hidden before it was delivered."
+	MessagePIIConfirm           = "🕵️ Your message seems to contain contact details like a phone number, username, email or link. Sharing them gives away who you are. Send it anyway?"
+	MessagePIIBlocked           = "🕵️ Sharing contact details like phone numbers, usernames, emails or links isn't allowed. Your message was not delivered."
+	MessageHeldMessageSent      = "✅ Your message was sent."
+	MessageHeldMessageDropped   = "🗑️ Your message was not sent."
+	MessageHeldMessageConfirmed = "👍 Confirmed. Your message still went through the usual checks, see below."
+	MessageHeldMessageGone      = "This message is no longer waiting to be sent."
+
+	MessageProfanityBlocked  = "🤐 Your message contains language that isn't allowed here and was not delivered."
+	MessageProfanityReported = "🤐 Your message contains language that isn't allowed here. It was not delivered and has been reported."
+
+	MessageMediaConsent         = "📷 Your partner wants to send you %s. Do you want to receive photos, videos, stickers and voice messages from them in this chat?"
+	MessageMediaConsentAllowed  = "✅ You allowed media in this chat."
+	MessageMediaConsentDeclined = "🚫 You declined media in this chat. Text messages still come through."
+	MessageMediaConsentExpired  = "This request is no longer valid."
+	MessageMediaWaiting         = "⌛ Your partner has to allow media first. I'll send yours on if they do."
+	MessageMediaAllowed         = "✅ Your partner allowed media. What you sent was delivered."
+	MessageMediaDeclined        = "🚫 Your partner doesn't want media in this chat. Only text is delivered."
+	MessageMediaHeldFull        = "🚫 Your partner hasn't answered yet and I'm already holding %d of your media messages, so this one wasn't kept."
+	MessageMediaBlocked         = "🚫 Your partner doesn't accept media. Only text is delivered."
+	MessageMediaSettingUsage    = "Media from partners: %s. Use /media ask to be asked in every chat, /media allow to always receive photos, videos, stickers and voice messages, or /media block to never receive them."
+	MessageMediaSettingSet      = "Media from partners is now set to: %s."
+
+	MessageTrustTooLow    = "🔒 You can't send %s yet. They unlock at the %s level, see /mylevel."
+	MessageMyLevel        = "🏅 Your level: %s (%d of %d)\nUnlocked: %s"
+	MessageNextLevel      = "To reach %s, have %d chats and use the bot for at least %d days."
+	MessageLevelPenalties = "Reports against you and poor ratings from your partners lower your level."
+
+	MessageRevealRequest       = "🤝 Your partner would like to swap Telegram accounts with you. If you accept, each of you gets a link to the other's account."
+	MessageRevealRequested     = "🤝 I asked your partner if they want to swap Telegram accounts. You'll get theirs if they accept."
+	MessageRevealPending       = "⌛ You already asked to swap accounts in this chat. Waiting for your partner."
+	MessageRevealDeclined      = "Your partner prefers to stay anonymous in this chat."
+	MessageRevealYouAccepted   = "✅ You accepted to swap accounts."
+	MessageRevealYouDeclined   = "You declined to swap accounts. You stay anonymous."
+	MessageRevealExpired       = "This request is no longer valid."
+	MessageRevealLocked        = "🔒 /reveal unlocks at the %s level, see /mylevel."
+	MessageRevealPartnerLocked = "Your partner can't swap accounts yet."
+	MessageRevealDone          = "You already swapped accounts in this chat."
+	MessageRevealed            = "🎉 You both agreed to swap accounts. Your partner is %s."
+
+	MessageSlowDown = "⏳ Slow down! You're sending messages too fast. Messages you send are dropped until your limit refills, which takes about %d seconds."
+
+	MessageRatePartner   = "How was your chat? Rate your partner to help us match you with people you'll enjoy talking to."
+	MessageRatingThanks  = "Thanks for your feedback! 🙏"
+	MessageAlreadyRated  = "You have already rated this chat."
+	MessageRatingExpired = "It's too late to rate this chat."
+
+	MessageAdminBanUsage       = "Usage: /ban <chat id> [duration], e.g. /ban 123456 7d. Without a duration the ban is permanent."
+	MessageAdminUnbanUsage     = "Usage: /unban <chat id>"
+	MessageAdminUserUsage      = "Usage: /user <chat id>"
+	MessageAdminUserNotFound   = "User %d not found."
+	MessageAdminBanned         = "User %d banned, ban ends: %s."
+	MessageAdminUnbanned       = "User %d unbanned."
+	MessageAdminNoReports      = "No pending reports."
+	MessageAdminReportDecided  = "This report has already been decided."
+	MessageAdminDecided        = "Decision: %s by %d."
+	MessageAdminReport         = "🚩 Report against %d (score %.2f, rating %.2f, %d bans)\nReporter: %s (weight %.2f)\nReason: %s\nFiled: %s\nSession: %s\nEvidence: %s"
+	MessageAdminUserProfile    = "👤 User %d\nState: %s\nGender: %s, looking for: %s\nRules accepted: v%d, under 18: %t\nSessions: %d, trust level: %s\nRating: %.2f (👍 %d, 👎 %d)\n\nReport score: %.2f\nReports against: %d (pending %d, upheld %d, dismissed %d)\nBy reason: %s\nReports filed: upheld %d, dismissed %d (credibility %.2f)\n\nFlagged messages: %d, contact details: %d, profanity: %d\nBans: %d, current ban: %s\nShadow pool: %s\nBlocked the bot: %t\n\nRecent chats ended with /stop:\n%s"
+	MessageAdminUserSession    = "%s with %d, ended %s"
+	MessageAdminUserNoSessions = "none in the last 7 days"
+	MessageAdminAppeal         = "📨 Appeal from %d (%d bans, ban ends: %s, report score %.2f)\n\n%s"
+	MessageAdminAppealDecided  = "This appeal has already been decided."
+	MessageAdminStats          = "📊 Users: about %d\nWaiting: %d\nPending reports: %d\n\nLast %d days:\nChats started: %d\nBans: %d"
+	MessageAdminCrisisStats    = "Crisis phrases, last %d days: %s"
+	MessageAdminSpam           = "🤖 User %d sent the same opening message to %d partners in the last %v. They were %s."
+
+	CallbackRulesAgree          = "rules_agree"
+	CallbackRulesMinor          = "rules_minor"
+	CallbackChallengePrefix     = "challenge:"
 	CallbackGenderPrefix        = "gender_"
 	CallbackPartnerGenderPrefix = "pgender_"
+	CallbackMediaAllow          = "media_allow"
+	CallbackMediaDecline        = "media_decline"
+	CallbackRevealAccept        = "reveal_yes"
+	CallbackRevealDecline       = "reveal_no"
+	CallbackHeldSend            = "held_send"
+	CallbackHeldCancel          = "held_cancel"
+	CallbackReportPrefix        = "report_"
+	CallbackReportCancel        = "report_cancel"
+	CallbackAdminApprove        = "adm_ok:"
+	CallbackAdminDismiss        = "adm_no:"
+	CallbackAdminBan            = "adm_ban:"
+	CallbackAppealAccept        = "apl_ok:"
+	CallbackAppealReject        = "apl_no:"
+	CallbackRateUp              = "rate_up:"
+	CallbackRateDown            = "rate_down:"
 )
 
 var Commands = []tgx.BotCommand{
@@ -74,6 +223,38 @@ var Commands = []tgx.BotCommand{
 		Command:     "/partnergender",
 		Description: "Set your preferred partner gender (e.g., /partnergender male).",
 	},
+	{
+		Command:     "/unsend",
+		Description: "Reply to your message to delete it from your partner's chat.",
+	},
+	{
+		Command:     "/autowipe",
+		Description: "Delete the conversation from both chats when the chat ends.",
+	},
+	{
+		Command:     "/protect",
+		Description: "Stop your partner from forwarding or saving your messages.",
+	},
+	{
+		Command:     "/viewonce",
+		Description: "Send photos and videos that disappear once seen.",
+	},
+	{
+		Command:     "/reveal",
+		Description: "Offer to swap Telegram accounts with your partner.",
+	},
+	{
+		Command:     "/mylevel",
+		Description: "See your trust level and what it unlocks.",
+	},
+	{
+		Command:     "/media",
+		Description: "Choose whether to receive photos and videos: ask, allow or block.",
+	},
+	{
+		Command:     "/appeal",
+		Description: "Ask the moderators to lift your ban.",
+	},
 	{
 		Command:     "/help",
 		Description: "Get a quick guide on how to use the bot.",
@@ -93,6 +274,11 @@ var inlineKeyboardButton = [][]models.InlineKeyboardButton{
 	},
 }
 
+var inlineKeyboardRules = [][]models.InlineKeyboardButton{
+	{{Text: "I'm 18+ and I agree", CallbackData: CallbackRulesAgree}},
+	{{Text: "I'm under 18 and I agree", CallbackData: CallbackRulesMinor}},
+}
+
 var inlineKeyboardGender = [][]models.InlineKeyboardButton{
 	{
 		{Text: "Male", CallbackData: CallbackGenderPrefix + "male"},
@@ -108,3 +294,42 @@ var inlineKeyboardPartnerGender = [][]models.InlineKeyboardButton{
 		{Text: "Any", CallbackData: CallbackPartnerGenderPrefix + "any"},
 	},
 }
+
+var inlineKeyboardConfirmSend = [][]models.InlineKeyboardButton{
+	{
+		{Text: "Send anyway", CallbackData: CallbackHeldSend},
+		{Text: "Cancel", CallbackData: CallbackHeldCancel},
+	},
+}
+
+var inlineKeyboardMediaConsent = [][]models.InlineKeyboardButton{
+	{
+		{Text: "Allow", CallbackData: CallbackMediaAllow},
+		{Text: "Decline", CallbackData: CallbackMediaDecline},
+	},
+}
+
+var inlineKeyboardReveal = [][]models.InlineKeyboardButton{
+	{
+		{Text: "Accept", CallbackData: CallbackRevealAccept},
+		{Text: "Decline", CallbackData: CallbackRevealDecline},
+	},
+}
+
+var inlineKeyboardReportReasons = [][]models.InlineKeyboardButton{
+	{
+		{Text: "Spam", CallbackData: CallbackReportPrefix + ReportReasonSpam},
+		{Text: "Harassment", CallbackData: CallbackReportPrefix + ReportReasonHarassment},
+	},
+	{
+		{Text: "Sexual content", CallbackData: CallbackReportPrefix + ReportReasonSexual},
+		{Text: "Underage", CallbackData: CallbackReportPrefix + ReportReasonUnderage},
+	},
+	{
+		{Text: "Scam", CallbackData: CallbackReportPrefix + ReportReasonScam},
+		{Text: "Other", CallbackData: CallbackReportPrefix + ReportReasonOther},
+	},
+	{
+		{Text: "Cancel", CallbackData: CallbackReportCancel},
+	},
+}